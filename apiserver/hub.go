@@ -50,33 +50,46 @@ func mkEventHash(evt *kcache.ConcCacheEvent) string {
 
 // Hub controls the communication between
 // calculation watchdogs and WebSocket clients.
+// It also forwards state changes of tasks
+// processed by TaskMaster to subscribed clients.
 type Hub struct {
 	Register        chan *WSClient
 	Unregister      chan *WSClient
+	RegisterTask    chan *TaskWSClient
+	UnregisterTask  chan *TaskWSClient
 	stop            chan bool
+	done            chan bool // closed once Start returns
 	watchdogFactory *kcache.RedisWatchdogFactory
 	clients         map[string]*WSClient // cache ID => client
 	watchdogs       map[string]Watcher
+	taskClients     map[*TaskWSClient]bool
 	cacheDB         *taskdb.ConcCacheDB
+	taskMaster      TaskMaster
 }
 
 // NewHub creates a proper instance of the Hub
 // with all the channels initialized
-func NewHub(cacheDB *taskdb.ConcCacheDB) *Hub {
+func NewHub(cacheDB *taskdb.ConcCacheDB, taskMaster TaskMaster) *Hub {
 	return &Hub{
 		watchdogFactory: kcache.NewRedisWatchdogFactory(cacheDB),
 		Register:        make(chan *WSClient),
 		Unregister:      make(chan *WSClient),
+		RegisterTask:    make(chan *TaskWSClient),
+		UnregisterTask:  make(chan *TaskWSClient),
 		stop:            make(chan bool, 1),
+		done:            make(chan bool),
 		watchdogs:       make(map[string]Watcher),
 		clients:         make(map[string]*WSClient),
+		taskClients:     make(map[*TaskWSClient]bool),
 		cacheDB:         cacheDB,
+		taskMaster:      taskMaster,
 	}
 }
 
 // Start starts listen on all the channels.
 // This must run in a goroutine.
 func (h *Hub) Start() {
+	defer close(h.done)
 	for {
 		select {
 
@@ -87,6 +100,10 @@ func (h *Hub) Start() {
 			for _, c := range h.clients {
 				c.Stop()
 			}
+			for c := range h.taskClients {
+				h.taskMaster.Unsubscribe(c.TaskID(), c.Incoming)
+				c.Stop()
+			}
 			return
 		case client := <-h.Register:
 			key := mkClientHash(client)
@@ -105,6 +122,15 @@ func (h *Hub) Start() {
 				delete(h.clients, key)
 			}
 			log.Printf("INFO: Unregistered %v", client)
+		case client := <-h.RegisterTask:
+			h.taskClients[client] = true
+			log.Printf("INFO: Registered %v", client)
+			go client.Run()
+			h.taskMaster.Subscribe(client.TaskID(), client.Incoming)
+		case client := <-h.UnregisterTask:
+			h.taskMaster.Unsubscribe(client.TaskID(), client.Incoming)
+			delete(h.taskClients, client)
+			log.Printf("INFO: Unregistered %v", client)
 		}
 	}
 }
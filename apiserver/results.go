@@ -0,0 +1,97 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/czcorpus/konserver/workpool"
+)
+
+// taskMeta is a task without its result. The shadowing
+// field makes encoding/json omit the embedded Result.
+type taskMeta struct {
+	*workpool.Task
+	Result interface{} `json:"result,omitempty"`
+}
+
+func acceptsGzip(request *http.Request) bool {
+	for _, enc := range strings.Split(request.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamResultFile writes a task with its offloaded result.
+// The output has the same structure as a task with an in-memory
+// result (i.e. {"taskID": ..., "result": ...}) but the result
+// file is streamed directly without being loaded into memory.
+func (s *APIServer) streamResultFile(writer http.ResponseWriter, request *http.Request, task *workpool.Task) {
+	resultFile, err := s.taskMaster.OpenResultFile(task.TaskID)
+	if err != nil {
+		log.Print("ERROR: ", err)
+		http.Error(writer, "Result not available", http.StatusNotFound)
+		return
+	}
+	defer resultFile.Close()
+	info, err := resultFile.Stat()
+	if err != nil {
+		log.Print("ERROR: ", err)
+		http.Error(writer, "Server error", http.StatusInternalServerError)
+		return
+	}
+	meta, err := json.Marshal(taskMeta{Task: task})
+	if err != nil {
+		log.Print("ERROR: ", err)
+		http.Error(writer, "Server error", http.StatusInternalServerError)
+		return
+	}
+	prefix := string(meta[:len(meta)-1]) + `,"result":`
+	suffix := "}\n"
+
+	writer.Header().Set("Content-Type", "application/json")
+	var out io.Writer = writer
+	if acceptsGzip(request) {
+		writer.Header().Set("Content-Encoding", "gzip")
+		writer.Header().Set("Vary", "Accept-Encoding")
+		gzWriter := gzip.NewWriter(writer)
+		defer gzWriter.Close()
+		out = gzWriter
+
+	} else {
+		size := int64(len(prefix)) + info.Size() + int64(len(suffix))
+		writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	_, err = io.WriteString(out, prefix)
+	if err == nil {
+		_, err = io.Copy(out, resultFile)
+	}
+	if err == nil {
+		_, err = io.WriteString(out, suffix)
+	}
+	if err != nil {
+		log.Printf("ERROR: failed to stream result of task %s: %s", task.TaskID, err)
+	}
+}
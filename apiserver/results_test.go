@@ -0,0 +1,104 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/czcorpus/konserver/workpool"
+	"github.com/czcorpus/konserver/workpool/nullqueue"
+	"github.com/stretchr/testify/assert"
+)
+
+// resultFileMaster serves result files from a directory
+type resultFileMaster struct {
+	nullqueue.NullQueue
+	dir string
+}
+
+func (rm *resultFileMaster) OpenResultFile(taskID string) (*os.File, error) {
+	return os.Open(filepath.Join(rm.dir, taskID+".json"))
+}
+
+func newResultFileServer(t *testing.T, result string) (*APIServer, func()) {
+	dir, err := ioutil.TempDir("", "konserver-results")
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "t1.json"), []byte(result), 0644))
+	server := &APIServer{taskMaster: &resultFileMaster{dir: dir}}
+	return server, func() { os.RemoveAll(dir) }
+}
+
+func decodeStreamedTask(t *testing.T, data []byte) map[string]interface{} {
+	var ans map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &ans), "invalid JSON: %s", data)
+	return ans
+}
+
+func TestStreamResultFile(t *testing.T) {
+	server, cleanup := newResultFileServer(t, `{"freqs": [1, 2, 3]}`)
+	defer cleanup()
+	task := &workpool.Task{TaskID: "t1", Fn: "freqs", Status: 2, ResultOffloaded: true, ResultSize: 20}
+	recorder := httptest.NewRecorder()
+	server.streamResultFile(recorder, httptest.NewRequest("GET", "/task/t1", nil), task)
+
+	assert.Equal(t, 200, recorder.Code)
+	body := recorder.Body.Bytes()
+	assert.Equal(t, strconv.Itoa(len(body)), recorder.Header().Get("Content-Length"))
+	assert.Empty(t, recorder.Header().Get("Content-Encoding"))
+	ans := decodeStreamedTask(t, body)
+	assert.Equal(t, "t1", ans["taskID"])
+	assert.Equal(t, "freqs", ans["fn"])
+	assert.Equal(t, true, ans["resultOffloaded"])
+	assert.Equal(t, map[string]interface{}{"freqs": []interface{}{1.0, 2.0, 3.0}}, ans["result"])
+}
+
+func TestStreamResultFileGzip(t *testing.T) {
+	server, cleanup := newResultFileServer(t, `"ok"`)
+	defer cleanup()
+	task := &workpool.Task{TaskID: "t1", Status: 2, ResultOffloaded: true}
+	request := httptest.NewRequest("GET", "/task/t1", nil)
+	request.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	recorder := httptest.NewRecorder()
+	server.streamResultFile(recorder, request, task)
+
+	assert.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	assert.Empty(t, recorder.Header().Get("Content-Length"))
+	reader, err := gzip.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	ans := decodeStreamedTask(t, body)
+	assert.Equal(t, "t1", ans["taskID"])
+	assert.Equal(t, "ok", ans["result"])
+}
+
+func TestStreamResultFileMissing(t *testing.T) {
+	server, cleanup := newResultFileServer(t, `[]`)
+	defer cleanup()
+	recorder := httptest.NewRecorder()
+	server.streamResultFile(recorder, httptest.NewRequest("GET", "/task/t2", nil),
+		&workpool.Task{TaskID: "t2", ResultOffloaded: true})
+	assert.Equal(t, 404, recorder.Code)
+}
@@ -19,12 +19,15 @@ package apiserver
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -60,7 +63,17 @@ type APIServer struct {
 type TaskMaster interface {
 	Info() *workpool.MasterInfo
 	GetTask(taskID string) *workpool.Task
-	SendTask(name string, jsonArgs []byte) *workpool.Task
+	ListTasks(filter *workpool.TaskFilter) (*workpool.TaskPage, error)
+	SendTask(name string, jsonArgs []byte, opts *workpool.TaskOptions) (*workpool.Task, error)
+	CancelTask(taskID string) *workpool.Task
+	SendWorkflow(spec *workpool.WorkflowSpec) (*workpool.Workflow, error)
+	GetWorkflow(workflowID string) *workpool.Workflow
+	Functions() []workpool.FunctionInfo
+	OpenResultFile(taskID string) (*os.File, error)
+	Subscribe(taskID string, ch chan *workpool.Task)
+	Unsubscribe(taskID string, ch chan *workpool.Task)
+	Drain(grace time.Duration) *workpool.Handover
+	Adopt(handover *workpool.Handover)
 	Start()
 	Stop()
 }
@@ -83,8 +96,13 @@ func NewAPIServer(hub *Hub, conf *Config, taskMaster TaskMaster, cacheRootPath s
 	}
 	ans.mux.HandleFunc(conf.URLPathRoot+"/", ans.serveHome)
 	ans.mux.HandleFunc(conf.URLPathRoot+"/ws", ans.serveNotifier)
+	ans.mux.HandleFunc(conf.URLPathRoot+"/ws/task", ans.serveTaskNotifier)
 	ans.mux.HandleFunc(conf.URLPathRoot+"/task/", ans.serveTasks)
+	ans.mux.HandleFunc(conf.URLPathRoot+"/tasks", ans.serveTaskList)
 	ans.mux.HandleFunc(conf.URLPathRoot+"/result/", ans.serveResults)
+	ans.mux.HandleFunc(conf.URLPathRoot+"/workflow", ans.serveWorkflows)
+	ans.mux.HandleFunc(conf.URLPathRoot+"/workflow/", ans.serveWorkflows)
+	ans.mux.HandleFunc(conf.URLPathRoot+"/functions", ans.serveFunctions)
 
 	return ans
 }
@@ -93,12 +111,15 @@ func NewAPIServer(hub *Hub, conf *Config, taskMaster TaskMaster, cacheRootPath s
 // it is closed.
 func (s *APIServer) Start() {
 	log.Printf("INFO: Serving at %s", s.conf.Address+s.conf.URLPathRoot)
+	var err error
 	if s.conf.SSLCertFile != "" && s.conf.SSLKeyFile != "" {
-		s.httpServer.ListenAndServeTLS(s.conf.SSLCertFile, s.conf.SSLKeyFile)
+		err = s.httpServer.ListenAndServeTLS(s.conf.SSLCertFile, s.conf.SSLKeyFile)
 	} else {
-		s.httpServer.ListenAndServe()
+		err = s.httpServer.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Print("ERROR: ", err)
 	}
-	http.ListenAndServe(s.conf.Address, s.mux)
 }
 
 // Stop gracefully stops the server
@@ -110,16 +131,114 @@ func (s *APIServer) Stop() {
 }
 
 func (s *APIServer) serveTasks(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodPost {
-		http.Error(writer, "Bad request", http.StatusBadRequest)
+	switch request.Method {
+	case http.MethodPost:
+		s.createTask(writer, request)
+	case http.MethodDelete:
+		s.cancelTask(writer, request)
+	default:
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parseTime parses either a unix timestamp or RFC3339 time
+func parseTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseTaskOptions reads optional task parameters
+// from URL arguments:
+// queue - a name of a queue,
+// priority - an integer,
+// eta - RFC3339 time or unix timestamp,
+// countdown - number of seconds to wait before execution,
+// idempotencyKey - a key identifying the submission (the
+// Idempotency-Key header can be used instead),
+// submitter - a client identification (the X-Submitter
+// header can be used instead)
+func parseTaskOptions(request *http.Request) (*workpool.TaskOptions, error) {
+	query := request.URL.Query()
+	opts := &workpool.TaskOptions{
+		Queue:          query.Get("queue"),
+		IdempotencyKey: request.Header.Get("Idempotency-Key"),
+	}
+	if key := query.Get("idempotencyKey"); key != "" {
+		opts.IdempotencyKey = key
+	}
+	opts.Submitter = request.Header.Get("X-Submitter")
+	if submitter := query.Get("submitter"); submitter != "" {
+		opts.Submitter = submitter
+	}
+	if p := query.Get("priority"); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %s", p)
+		}
+		opts.Priority = &priority
+	}
+	if query.Get("eta") != "" && query.Get("countdown") != "" {
+		return nil, fmt.Errorf("eta and countdown cannot be used together")
+	}
+	if eta := query.Get("eta"); eta != "" {
+		t, err := parseTime(eta)
+		if err != nil {
+			return nil, fmt.Errorf("invalid eta %s", eta)
+		}
+		opts.ETA = t
 	}
+	if countdown := query.Get("countdown"); countdown != "" {
+		secs, err := strconv.ParseFloat(countdown, 64)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("invalid countdown %s", countdown)
+		}
+		opts.ETA = time.Now().Add(time.Duration(secs * float64(time.Second)))
+	}
+	return opts, nil
+}
+
+func (s *APIServer) createTask(writer http.ResponseWriter, request *http.Request) {
 	sPos := strings.LastIndex(request.URL.Path, "/")
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
 		// TODO handle error properly
 		log.Print("ERROR: ", err)
 	}
-	task := s.taskMaster.SendTask(request.URL.Path[sPos+1:], body)
+	fn := request.URL.Path[sPos+1:]
+	if fn == "" {
+		http.Error(writer, "missing task function", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseTaskOptions(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	task, err := s.taskMaster.SendTask(fn, body, opts)
+	if fErr, ok := err.(*workpool.UnknownFunctionError); ok {
+		http.Error(writer, fErr.Error(), http.StatusNotFound)
+		return
+
+	} else if vErr, ok := err.(*workpool.ValidationError); ok {
+		writeValidationError(writer, vErr)
+		return
+
+	} else if qErr, ok := err.(*workpool.QueueFullError); ok {
+		writer.Header().Set("Retry-After", strconv.Itoa(qErr.RetryAfterSeconds))
+		http.Error(writer, qErr.Error(), http.StatusServiceUnavailable)
+		return
+
+	} else if err == workpool.ErrDraining {
+		writer.Header().Set("Retry-After", strconv.Itoa(workpool.DrainRetryAfterSeconds))
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	ans, err := json.Marshal(task)
 	if err != nil {
 		// TODO
@@ -129,15 +248,152 @@ func (s *APIServer) serveTasks(writer http.ResponseWriter, request *http.Request
 	io.WriteString(writer, string(ans))
 }
 
+// parseTaskFilter reads task list parameters from URL arguments:
+// status - comma-separated status names or codes,
+// fn - a task function,
+// submitter - a client identification,
+// createdFrom, createdTo - RFC3339 time or unix timestamp,
+// sort - created (default), updated or started,
+// order - asc (default) or desc,
+// limit - max. number of tasks,
+// cursor - nextCursor of the previous page
+func parseTaskFilter(request *http.Request) (*workpool.TaskFilter, error) {
+	query := request.URL.Query()
+	filter := &workpool.TaskFilter{
+		Fn:        query.Get("fn"),
+		Submitter: query.Get("submitter"),
+		SortBy:    query.Get("sort"),
+		Cursor:    query.Get("cursor"),
+	}
+	if statuses := query.Get("status"); statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			status, err := workpool.ParseTaskStatus(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	if from := query.Get("createdFrom"); from != "" {
+		t, err := parseTime(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid createdFrom %s", from)
+		}
+		filter.CreatedFrom = t.Unix()
+	}
+	if to := query.Get("createdTo"); to != "" {
+		t, err := parseTime(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid createdTo %s", to)
+		}
+		filter.CreatedTo = t.Unix()
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return nil, fmt.Errorf("invalid order %s", order)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 {
+			return nil, fmt.Errorf("invalid limit %s", limit)
+		}
+	}
+	return filter, nil
+}
+
+// taskListPage is a response body of the task list. Listed
+// tasks are encoded without results (see taskMeta).
+type taskListPage struct {
+	Tasks      []taskMeta `json:"tasks"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// serveTaskList lists tasks (GET /tasks), see parseTaskFilter
+// for supported URL arguments
+func (s *APIServer) serveTaskList(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseTaskFilter(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.taskMaster.ListTasks(filter)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ans := taskListPage{Tasks: make([]taskMeta, len(page.Tasks)), NextCursor: page.NextCursor}
+	for i, task := range page.Tasks {
+		ans.Tasks[i] = taskMeta{Task: task}
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(writer)
+	err = enc.Encode(ans)
+	if err != nil {
+		http.Error(writer, "Server error", http.StatusInternalServerError)
+	}
+}
+
+// validationErrorResponse is a response body
+// for rejected task arguments
+type validationErrorResponse struct {
+	Error string `json:"error"`
+	*workpool.ValidationError
+}
+
+// writeValidationError writes a structured 400 response
+// listing all the violated fields
+func writeValidationError(writer http.ResponseWriter, vErr *workpool.ValidationError) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusBadRequest)
+	enc := json.NewEncoder(writer)
+	err := enc.Encode(validationErrorResponse{Error: vErr.Error(), ValidationError: vErr})
+	if err != nil {
+		log.Print("ERROR: ", err)
+	}
+}
+
+// cancelTask cancels a task (DELETE /task/[task ID]).
+// In case the task has already finished, 409 is returned.
+func (s *APIServer) cancelTask(writer http.ResponseWriter, request *http.Request) {
+	sPos := strings.LastIndex(request.URL.Path, "/")
+	task := s.taskMaster.CancelTask(request.URL.Path[sPos+1:])
+	if task == nil {
+		http.Error(writer, "Not found", http.StatusNotFound)
+
+	} else if !task.IsCancelled() {
+		http.Error(writer, fmt.Sprintf("task %s already finished", task.TaskID), http.StatusConflict)
+
+	} else {
+		writer.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(writer)
+		err := enc.Encode(task)
+		if err != nil {
+			http.Error(writer, "Server error", http.StatusInternalServerError)
+		}
+	}
+}
+
 func (s *APIServer) serveResults(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		http.Error(writer, "Bad request", http.StatusBadRequest)
+		return
 	}
 	sPos := strings.LastIndex(request.URL.Path, "/")
 	taskResult := s.taskMaster.GetTask(request.URL.Path[sPos+1:])
 	if taskResult == nil {
 		http.Error(writer, "Not found", http.StatusNotFound)
 
+	} else if taskResult.ResultOffloaded {
+		s.streamResultFile(writer, request, taskResult)
+
 	} else {
 		writer.Header().Set("Content-Type", "application/json")
 		enc := json.NewEncoder(writer)
@@ -148,8 +404,83 @@ func (s *APIServer) serveResults(writer http.ResponseWriter, request *http.Reque
 	}
 }
 
-func (s *APIServer) serveNotifier(writer http.ResponseWriter, request *http.Request) {
-	upgrader := websocket.Upgrader{
+// serveWorkflows creates a new workflow (POST with JSON-encoded
+// workpool.WorkflowSpec as a body) or returns an existing
+// one (GET /workflow/[workflow ID])
+func (s *APIServer) serveWorkflows(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodPost:
+		s.createWorkflow(writer, request)
+	case http.MethodGet:
+		s.getWorkflow(writer, request)
+	default:
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) createWorkflow(writer http.ResponseWriter, request *http.Request) {
+	var spec workpool.WorkflowSpec
+	dec := json.NewDecoder(request.Body)
+	err := dec.Decode(&spec)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("invalid workflow: %s", err), http.StatusBadRequest)
+		return
+	}
+	workflow, err := s.taskMaster.SendWorkflow(&spec)
+	if vErr, ok := err.(*workpool.ValidationError); ok {
+		writeValidationError(writer, vErr)
+		return
+
+	} else if err == workpool.ErrDraining {
+		writer.Header().Set("Retry-After", strconv.Itoa(workpool.DrainRetryAfterSeconds))
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(writer)
+	err = enc.Encode(workflow)
+	if err != nil {
+		http.Error(writer, "Server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) getWorkflow(writer http.ResponseWriter, request *http.Request) {
+	sPos := strings.LastIndex(request.URL.Path, "/")
+	workflow := s.taskMaster.GetWorkflow(request.URL.Path[sPos+1:])
+	if workflow == nil {
+		http.Error(writer, "Not found", http.StatusNotFound)
+
+	} else {
+		writer.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(writer)
+		err := enc.Encode(workflow)
+		if err != nil {
+			http.Error(writer, "Server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// serveFunctions lists task functions announced
+// by workers (GET /functions)
+func (s *APIServer) serveFunctions(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(writer)
+	err := enc.Encode(s.taskMaster.Functions())
+	if err != nil {
+		http.Error(writer, "Server error", http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) createUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		CheckOrigin: func(r *http.Request) bool {
@@ -163,7 +494,10 @@ func (s *APIServer) serveNotifier(writer http.ResponseWriter, request *http.Requ
 			return false
 		},
 	}
-	conn, err := upgrader.Upgrade(writer, request, nil)
+}
+
+func (s *APIServer) serveNotifier(writer http.ResponseWriter, request *http.Request) {
+	conn, err := s.createUpgrader().Upgrade(writer, request, nil)
 	if err != nil {
 		log.Print("ERROR: ", err)
 		return
@@ -179,6 +513,22 @@ func (s *APIServer) serveNotifier(writer http.ResponseWriter, request *http.Requ
 	s.hub.Register <- NewWSClient(cacheIdent, s.hub, conn)
 }
 
+// serveTaskNotifier sends status changes of a task
+// (specified by 'taskId' URL argument) via WebSocket
+func (s *APIServer) serveTaskNotifier(writer http.ResponseWriter, request *http.Request) {
+	taskID := request.URL.Query().Get("taskId")
+	if s.taskMaster.GetTask(taskID) == nil {
+		http.Error(writer, "Not found", http.StatusNotFound)
+		return
+	}
+	conn, err := s.createUpgrader().Upgrade(writer, request, nil)
+	if err != nil {
+		log.Print("ERROR: ", err)
+		return
+	}
+	s.hub.RegisterTask <- NewTaskWSClient(taskID, s.hub, conn)
+}
+
 // serveHome provides some information about running server
 func (s *APIServer) serveHome(writer http.ResponseWriter, request *http.Request) {
 	if request.URL.Path != s.conf.URLPathRoot+"/info" {
@@ -0,0 +1,230 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/konserver/workpool"
+	"github.com/czcorpus/konserver/workpool/nullqueue"
+	"github.com/stretchr/testify/assert"
+)
+
+// cancelMaster cancels tasks with status "waiting"
+type cancelMaster struct {
+	nullqueue.NullQueue
+	tasks map[string]*workpool.Task
+}
+
+func (cm *cancelMaster) CancelTask(taskID string) *workpool.Task {
+	task, ok := cm.tasks[taskID]
+	if !ok {
+		return nil
+	}
+	if task.Status == 0 {
+		task.Status = 3 // cancelled
+	}
+	return task
+}
+
+func TestParseTaskOptionsETA(t *testing.T) {
+	opts, err := parseTaskOptions(httptest.NewRequest("POST", "/task/foo?eta=2030-01-02T10:00:00Z", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2030, 1, 2, 10, 0, 0, 0, time.UTC).Unix(), opts.ETA.Unix())
+
+	opts, err = parseTaskOptions(httptest.NewRequest("POST", "/task/foo?eta=1893578400", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1893578400), opts.ETA.Unix())
+
+	opts, err = parseTaskOptions(httptest.NewRequest("POST", "/task/foo", nil))
+	assert.Nil(t, err)
+	assert.True(t, opts.ETA.IsZero())
+}
+
+func TestParseTaskOptionsCountdown(t *testing.T) {
+	before := time.Now()
+	opts, err := parseTaskOptions(httptest.NewRequest("POST", "/task/foo?countdown=2.5", nil))
+	assert.Nil(t, err)
+	assert.False(t, opts.ETA.Before(before.Add(2500*time.Millisecond)))
+	assert.False(t, opts.ETA.After(time.Now().Add(2500*time.Millisecond)))
+}
+
+func TestParseTaskOptionsInvalidETA(t *testing.T) {
+	for _, query := range []string{"eta=tomorrow", "countdown=x", "countdown=-1", "eta=1893578400&countdown=10"} {
+		_, err := parseTaskOptions(httptest.NewRequest("POST", "/task/foo?"+query, nil))
+		assert.Error(t, err, query)
+	}
+}
+
+func TestCancelTaskHandler(t *testing.T) {
+	server := &APIServer{taskMaster: &cancelMaster{tasks: map[string]*workpool.Task{
+		"t1": {TaskID: "t1", Status: 0},
+		"t2": {TaskID: "t2", Status: 2},
+	}}}
+
+	recorder := httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("DELETE", "/task/t1", nil))
+	assert.Equal(t, 200, recorder.Code)
+	var task workpool.Task
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, "t1", task.TaskID)
+	assert.True(t, task.IsCancelled())
+
+	recorder = httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("DELETE", "/task/t2", nil))
+	assert.Equal(t, 409, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("DELETE", "/task/t3", nil))
+	assert.Equal(t, 404, recorder.Code)
+}
+
+func TestCreateTaskQueueFull(t *testing.T) {
+	master := workpool.NewMaster(&workpool.MasterConf{PoolSize: 1, MaxQueueLength: 1}, workpool.NewMemoryTaskStore())
+	server := &APIServer{taskMaster: master}
+
+	recorder := httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("POST", "/task/foo", strings.NewReader(`{}`)))
+	assert.Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("POST", "/task/foo", strings.NewReader(`{}`)))
+	assert.Equal(t, 503, recorder.Code)
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+}
+
+func TestFunctionsAnnouncedByWorker(t *testing.T) {
+	master := workpool.NewMaster(&workpool.MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `echo '{"control": "hello", "functions": [{"name": "foo"}]}'; cat > /dev/null`},
+		ExecMaxSeconds: 10,
+	}, workpool.NewMemoryTaskStore())
+	master.Start()
+	defer master.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(master.Functions()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	server := &APIServer{taskMaster: master}
+
+	recorder := httptest.NewRecorder()
+	server.serveFunctions(recorder, httptest.NewRequest("GET", "/functions", nil))
+	assert.Equal(t, 200, recorder.Code)
+	var functions []workpool.FunctionInfo
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &functions))
+	assert.Equal(t, []workpool.FunctionInfo{{Name: "foo", Pool: "default"}}, functions)
+
+	recorder = httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("POST", "/task/bar", strings.NewReader(`{}`)))
+	assert.Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("POST", "/task/foo", strings.NewReader(`{}`)))
+	assert.Equal(t, 200, recorder.Code)
+}
+
+func TestCreateTaskInvalidArgs(t *testing.T) {
+	master := workpool.NewMaster(&workpool.MasterConf{
+		PoolSize: 1,
+		ArgsSchemas: map[string]json.RawMessage{
+			"foo": json.RawMessage(`{"type": "object", "required": ["a"], "properties": {"a": {"type": "integer"}}}`),
+		},
+	}, workpool.NewMemoryTaskStore())
+	server := &APIServer{taskMaster: master}
+
+	recorder := httptest.NewRecorder()
+	server.serveTasks(recorder, httptest.NewRequest("POST", "/task/foo", strings.NewReader(`{"b": 1}`)))
+	assert.Equal(t, 400, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var body struct {
+		Error      string                     `json:"error"`
+		Fn         string                     `json:"fn"`
+		Violations []workpool.SchemaViolation `json:"violations"`
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	assert.Equal(t, "foo", body.Fn)
+	assert.Equal(t, []workpool.SchemaViolation{{Field: "args.a", Message: "missing required field"}}, body.Violations)
+	assert.Equal(t, "invalid arguments of foo (args.a: missing required field)", body.Error)
+}
+
+func TestParseTaskFilter(t *testing.T) {
+	filter, err := parseTaskFilter(httptest.NewRequest("GET",
+		"/tasks?status=running,5&fn=foo&submitter=x&createdFrom=1893578400&createdTo=2030-01-02T10:00:00Z&sort=updated&order=desc&limit=10&cursor=abc", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, &workpool.TaskFilter{
+		Statuses:    []int{1, 5},
+		Fn:          "foo",
+		Submitter:   "x",
+		CreatedFrom: 1893578400,
+		CreatedTo:   time.Date(2030, 1, 2, 10, 0, 0, 0, time.UTC).Unix(),
+		SortBy:      workpool.TaskSortUpdated,
+		Descending:  true,
+		Limit:       10,
+		Cursor:      "abc",
+	}, filter)
+
+	filter, err = parseTaskFilter(httptest.NewRequest("GET", "/tasks", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, &workpool.TaskFilter{}, filter)
+}
+
+func TestParseTaskFilterInvalid(t *testing.T) {
+	for _, query := range []string{"status=done", "createdFrom=yesterday", "createdTo=x", "order=up", "limit=0", "limit=-1", "limit=x"} {
+		_, err := parseTaskFilter(httptest.NewRequest("GET", "/tasks?"+query, nil))
+		assert.Error(t, err, query)
+	}
+}
+
+func TestServeTaskList(t *testing.T) {
+	store := workpool.NewMemoryTaskStore()
+	store.Put(&workpool.Task{TaskID: "a", Fn: "foo", Status: 2, Created: 10, Result: "big"})
+	store.Put(&workpool.Task{TaskID: "b", Fn: "foo", Status: 2, Created: 20, Result: "big"})
+	server := &APIServer{taskMaster: workpool.NewMaster(&workpool.MasterConf{PoolSize: 1}, store)}
+
+	recorder := httptest.NewRecorder()
+	server.serveTaskList(recorder, httptest.NewRequest("GET", "/tasks?limit=1", nil))
+	assert.Equal(t, 200, recorder.Code)
+	var page struct {
+		Tasks      []map[string]interface{} `json:"tasks"`
+		NextCursor string                   `json:"nextCursor"`
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Equal(t, 1, len(page.Tasks))
+	assert.Equal(t, "a", page.Tasks[0]["taskID"])
+	_, hasResult := page.Tasks[0]["result"]
+	assert.False(t, hasResult)
+	assert.NotEqual(t, "", page.NextCursor)
+
+	recorder = httptest.NewRecorder()
+	server.serveTaskList(recorder, httptest.NewRequest("GET", "/tasks?limit=1&cursor="+page.NextCursor, nil))
+	assert.Equal(t, 200, recorder.Code)
+	page.NextCursor = ""
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Equal(t, "b", page.Tasks[0]["taskID"])
+	assert.Equal(t, "", page.NextCursor)
+
+	for _, query := range []string{"cursor=!!!", "cursor=eA", "limit=0", "limit=x", "sort=fn"} {
+		recorder = httptest.NewRecorder()
+		server.serveTaskList(recorder, httptest.NewRequest("GET", "/tasks?"+query, nil))
+		assert.Equal(t, 400, recorder.Code, query)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/czcorpus/konserver/workpool"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	taskClientIdleTimeout = 5 * time.Minute
+	taskClientBufferSize  = 10
+)
+
+// TaskWSClient keeps connection with actual remote
+// client (= browser) interested in state changes
+// of a task processed by TaskMaster.
+type TaskWSClient struct {
+	taskID   string
+	hub      *Hub
+	conn     *websocket.Conn
+	Incoming chan *workpool.Task
+	stop     chan bool
+
+	// disconnected is closed once the remote
+	// client closes the connection
+	disconnected chan bool
+}
+
+// NewTaskWSClient creates a proper instance of TaskWSClient
+// with all the channels initialized.
+func NewTaskWSClient(taskID string, hub *Hub, conn *websocket.Conn) *TaskWSClient {
+	return &TaskWSClient{
+		taskID:   taskID,
+		hub:      hub,
+		conn:     conn,
+		Incoming: make(chan *workpool.Task, taskClientBufferSize),
+		stop:     make(chan bool, 1),
+
+		disconnected: make(chan bool),
+	}
+}
+
+func (c *TaskWSClient) String() string {
+	return fmt.Sprintf("TaskWSClient[%s]", c.taskID)
+}
+
+// TaskID returns ID of the watched task
+func (c *TaskWSClient) TaskID() string {
+	return c.taskID
+}
+
+// Stop asynchronously stops the client
+// by sending 'true' to a respective channel.
+func (c *TaskWSClient) Stop() {
+	c.stop <- true
+}
+
+// readMessages reads (and ignores) messages sent by the remote
+// client. This is needed to process control messages and to
+// find out the client has disconnected.
+func (c *TaskWSClient) readMessages() {
+	defer close(c.disconnected)
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Run starts to listen on all the channels and sends
+// each received task state to the remote client. Once
+// the task is done, the connection is closed.
+// This method must be used within a goroutine.
+func (c *TaskWSClient) Run() {
+	defer c.conn.Close()
+	go c.readMessages()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.disconnected:
+			log.Printf("INFO: Client for task %s disconnected.", c.taskID)
+			c.unregister()
+			return
+		case task := <-c.Incoming:
+			err := c.conn.WriteJSON(task)
+			if err != nil {
+				log.Printf("ERROR: Failed to send task status to %v: %s", c, err)
+				c.unregister()
+				return
+			}
+			if task.IsDone() {
+				c.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "DONE"))
+				c.unregister()
+				return
+			}
+		case <-time.After(taskClientIdleTimeout):
+			log.Printf("INFO: Closing client for task %s after timeout.", c.taskID)
+			c.unregister()
+			return
+		}
+	}
+}
+
+// unregister removes the client from the hub. In case
+// the hub is being stopped (or it has been stopped already),
+// the client just gives up so it does not block forever.
+func (c *TaskWSClient) unregister() {
+	select {
+	case c.hub.UnregisterTask <- c:
+	case <-c.stop:
+	case <-c.hub.done:
+	}
+}
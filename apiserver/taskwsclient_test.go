@@ -0,0 +1,165 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/konserver/workpool"
+	"github.com/czcorpus/konserver/workpool/nullqueue"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+const testOrigin = "http://kontext.test"
+
+// subscriptionMaster keeps tasks along with
+// channels subscribed to them
+type subscriptionMaster struct {
+	nullqueue.NullQueue
+	mutex       *sync.Mutex
+	tasks       map[string]*workpool.Task
+	subscribers map[string]chan *workpool.Task
+}
+
+func newSubscriptionMaster(taskIDs ...string) *subscriptionMaster {
+	ans := &subscriptionMaster{
+		mutex:       &sync.Mutex{},
+		tasks:       make(map[string]*workpool.Task),
+		subscribers: make(map[string]chan *workpool.Task),
+	}
+	for _, taskID := range taskIDs {
+		ans.tasks[taskID] = &workpool.Task{TaskID: taskID}
+	}
+	return ans
+}
+
+func (sm *subscriptionMaster) GetTask(taskID string) *workpool.Task {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.tasks[taskID]
+}
+
+func (sm *subscriptionMaster) Subscribe(taskID string, ch chan *workpool.Task) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	sm.subscribers[taskID] = ch
+	ch <- sm.tasks[taskID]
+}
+
+func (sm *subscriptionMaster) Unsubscribe(taskID string, ch chan *workpool.Task) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	delete(sm.subscribers, taskID)
+}
+
+func (sm *subscriptionMaster) subscriber(taskID string) chan *workpool.Task {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.subscribers[taskID]
+}
+
+// waitForSubscriber waits until a task is (subscribed == true)
+// or is not (subscribed == false) watched by a client
+func (sm *subscriptionMaster) waitForSubscriber(t *testing.T, taskID string, subscribed bool) chan *workpool.Task {
+	for i := 0; i < 100; i++ {
+		if ch := sm.subscriber(taskID); (ch != nil) == subscribed {
+			return ch
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("unexpected subscription state of task %s", taskID)
+	return nil
+}
+
+func newTaskWSServer(master TaskMaster) (*httptest.Server, *Hub) {
+	hub := NewHub(nil, master)
+	go hub.Start()
+	server := NewAPIServer(hub, &Config{URLPathRoot: "/atn", AllowedOrigins: []string{testOrigin}}, master, "")
+	return httptest.NewServer(server.mux), hub
+}
+
+func dialTask(t *testing.T, server *httptest.Server, taskID string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/atn/ws/task?taskId=" + taskID
+	return websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{testOrigin}})
+}
+
+func TestTaskWSClientReceivesUpdates(t *testing.T) {
+	master := newSubscriptionMaster("t1", "t2")
+	server, hub := newTaskWSServer(master)
+	defer server.Close()
+	defer hub.Stop()
+
+	conn, _, err := dialTask(t, server, "t1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	ch := master.waitForSubscriber(t, "t1", true)
+	assert.Nil(t, master.subscriber("t2"))
+
+	var task workpool.Task
+	assert.Nil(t, conn.ReadJSON(&task))
+	assert.Equal(t, "t1", task.TaskID)
+	assert.Equal(t, 0, task.Status)
+
+	ch <- &workpool.Task{TaskID: "t1", Status: 1, Progress: &workpool.TaskProgress{Percent: 50}}
+	assert.Nil(t, conn.ReadJSON(&task))
+	assert.Equal(t, 1, task.Status)
+	assert.Equal(t, 50.0, task.Progress.Percent)
+
+	ch <- &workpool.Task{TaskID: "t1", Status: 2, Result: "ok"}
+	assert.Nil(t, conn.ReadJSON(&task))
+	assert.Equal(t, 2, task.Status)
+	assert.Equal(t, "ok", task.Result)
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	master.waitForSubscriber(t, "t1", false)
+}
+
+func TestTaskWSClientUnregistersOnDisconnect(t *testing.T) {
+	master := newSubscriptionMaster("t1")
+	server, hub := newTaskWSServer(master)
+	defer server.Close()
+	defer hub.Stop()
+
+	conn, _, err := dialTask(t, server, "t1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	master.waitForSubscriber(t, "t1", true)
+	conn.Close()
+	master.waitForSubscriber(t, "t1", false)
+}
+
+func TestTaskWSClientUnknownTask(t *testing.T) {
+	master := newSubscriptionMaster()
+	server, hub := newTaskWSServer(master)
+	defer server.Close()
+	defer hub.Stop()
+
+	_, resp, err := dialTask(t, server, "t1")
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package celery
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Broker is an interface to a message broker the consumer
+// reads Celery messages from and writes task results to
+type Broker interface {
+
+	// Fetch atomically moves the oldest message from a queue to
+	// a list of unacknowledged messages and returns it. In case
+	// there is no message within timeout, an empty string is
+	// returned.
+	Fetch(queue string, unacked string, timeout time.Duration) (string, error)
+
+	// Ack removes a message from a list of unacknowledged messages
+	Ack(unacked string, msg string) error
+
+	// Requeue moves a message from a list of unacknowledged
+	// messages back to its queue so it is fetched again
+	Requeue(queue string, unacked string, msg string) error
+
+	// Recover moves all the unacknowledged messages (e.g. the ones
+	// left by a crashed consumer) back to a queue and returns
+	// their number
+	Recover(queue string, unacked string) (int, error)
+
+	// StoreResult stores a result under a key
+	// and notifies clients subscribed to the key
+	StoreResult(key string, value []byte, expiration time.Duration) error
+}
+
+// RedisBroker is a Broker accessing lists
+// created by kombu's Redis transport
+type RedisBroker struct {
+	db *redis.Client
+}
+
+// NewRedisBroker creates a properly configured
+// instance of RedisBroker
+func NewRedisBroker(conf *Conf) *RedisBroker {
+	return &RedisBroker{
+		db: redis.NewClient(&redis.Options{
+			Addr:     conf.BrokerAddress,
+			Password: conf.BrokerPassword,
+			DB:       conf.BrokerDatabase,
+		}),
+	}
+}
+
+// Fetch moves a message from the end of the queue
+// (kombu pushes messages to its start) to the start
+// of the list of unacknowledged messages
+func (rb *RedisBroker) Fetch(queue string, unacked string, timeout time.Duration) (string, error) {
+	msg, err := rb.db.BRPopLPush(queue, unacked, timeout).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return msg, err
+}
+
+func (rb *RedisBroker) Ack(unacked string, msg string) error {
+	return rb.db.LRem(unacked, 1, msg).Err()
+}
+
+// Requeue puts a message back to the end
+// of the queue so it is fetched next
+func (rb *RedisBroker) Requeue(queue string, unacked string, msg string) error {
+	_, err := rb.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(queue, msg)
+		pipe.LRem(unacked, 1, msg)
+		return nil
+	})
+	return err
+}
+
+// Recover moves unacknowledged messages back to the end
+// of the queue (the oldest message is fetched first).
+// It must not run concurrently with Fetch using the same
+// list of unacknowledged messages.
+func (rb *RedisBroker) Recover(queue string, unacked string) (int, error) {
+	msgs, err := rb.db.LRange(unacked, 0, -1).Result()
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	_, err = rb.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		// the list starts with the newest message
+		for _, msg := range msgs {
+			pipe.RPush(queue, msg)
+		}
+		pipe.Del(unacked)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
+
+// StoreResult stores a result the same way Celery's
+// Redis result backend does (SET + PUBLISH)
+func (rb *RedisBroker) StoreResult(key string, value []byte, expiration time.Duration) error {
+	_, err := rb.db.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(key, value, expiration)
+		pipe.Publish(key, value)
+		return nil
+	})
+	return err
+}
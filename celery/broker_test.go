@@ -0,0 +1,123 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package celery
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testQueue   = "konserver-test.queue"
+	testUnacked = "konserver-test.unacked"
+	testResult  = "konserver-test.result"
+
+	// testRedisDatabase is a database the tests
+	// use to keep away from real data
+	testRedisDatabase = 15
+)
+
+// newTestBroker connects to a Redis server (or any compatible
+// stand-in) specified by REDIS_TEST_ADDRESS (default is
+// 127.0.0.1:6379). In case there is no such server, the test
+// is skipped. The returned function removes all the test keys.
+func newTestBroker(t *testing.T) (*RedisBroker, func()) {
+	addr := os.Getenv("REDIS_TEST_ADDRESS")
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+	}
+	rb := NewRedisBroker(&Conf{BrokerAddress: addr, BrokerDatabase: testRedisDatabase})
+	if err := rb.db.Ping().Err(); err != nil {
+		t.Skipf("no Redis server available at %s: %s", addr, err)
+	}
+	cleanup := func() {
+		for _, key := range []string{testQueue, testUnacked, testResult} {
+			rb.db.Del(key)
+		}
+	}
+	cleanup()
+	return rb, cleanup
+}
+
+func TestRedisBrokerFetchAck(t *testing.T) {
+	rb, cleanup := newTestBroker(t)
+	defer cleanup()
+	// kombu pushes new messages to the start of the list
+	assert.Nil(t, rb.db.LPush(testQueue, "m1", "m2").Err())
+
+	msg, err := rb.Fetch(testQueue, testUnacked, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "m1", msg)
+	assert.Equal(t, []string{"m1"}, rb.db.LRange(testUnacked, 0, -1).Val())
+	assert.Nil(t, rb.Ack(testUnacked, msg))
+	assert.Empty(t, rb.db.LRange(testUnacked, 0, -1).Val())
+
+	msg, err = rb.Fetch(testQueue, testUnacked, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "m2", msg)
+	msg, err = rb.Fetch(testQueue, testUnacked, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "", msg)
+}
+
+func TestRedisBrokerRequeue(t *testing.T) {
+	rb, cleanup := newTestBroker(t)
+	defer cleanup()
+	assert.Nil(t, rb.db.LPush(testQueue, "m1", "m2").Err())
+	msg, err := rb.Fetch(testQueue, testUnacked, time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, rb.Requeue(testQueue, testUnacked, msg))
+	assert.Empty(t, rb.db.LRange(testUnacked, 0, -1).Val())
+
+	// the requeued message is fetched again first
+	msg, err = rb.Fetch(testQueue, testUnacked, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "m1", msg)
+}
+
+func TestRedisBrokerRecover(t *testing.T) {
+	rb, cleanup := newTestBroker(t)
+	defer cleanup()
+	assert.Nil(t, rb.db.LPush(testQueue, "m1", "m2", "m3").Err())
+	for i := 0; i < 2; i++ {
+		_, err := rb.Fetch(testQueue, testUnacked, time.Second)
+		assert.Nil(t, err)
+	}
+	n, err := rb.Recover(testQueue, testUnacked)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Empty(t, rb.db.LRange(testUnacked, 0, -1).Val())
+
+	// the original order is kept
+	for _, expected := range []string{"m1", "m2", "m3"} {
+		msg, err := rb.Fetch(testQueue, testUnacked, time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, msg)
+	}
+}
+
+func TestRedisBrokerStoreResult(t *testing.T) {
+	rb, cleanup := newTestBroker(t)
+	defer cleanup()
+	assert.Nil(t, rb.StoreResult(testResult, []byte(`{"status": "SUCCESS"}`), time.Hour))
+	assert.Equal(t, `{"status": "SUCCESS"}`, rb.db.Get(testResult).Val())
+	ttl := rb.db.TTL(testResult).Val()
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour, "unexpected TTL %v", ttl)
+}
@@ -0,0 +1,428 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package celery allows konserver to replace Celery workers. It
+// consumes Celery protocol v2 messages from Redis lists (as written
+// by kombu's Redis transport), executes them via workpool.Master
+// and optionally stores their results the same way Celery's Redis
+// result backend does so Celery clients (AsyncResult) can read them.
+package celery
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/czcorpus/konserver/workpool"
+)
+
+const (
+	defaultQueue                = "celery"
+	defaultResultExpiresSeconds = 86400
+	defaultPollTimeoutSeconds   = 1
+
+	unackedKeyPrefix = "konserver.unacked."
+	resultKeyPrefix  = "celery-task-meta-"
+
+	// requeueDelay is a pause after a message has been returned
+	// to its queue (e.g. because the task queue is full)
+	requeueDelay = 2 * time.Second
+
+	// errorDelay is a pause after a broker error
+	errorDelay = 5 * time.Second
+
+	// resultCheckInterval specifies how often the state of
+	// a watched task is checked in case a notification is missed
+	resultCheckInterval = 5 * time.Second
+
+	// Celery task states
+	stateSuccess = "SUCCESS"
+	stateFailure = "FAILURE"
+	stateRevoked = "REVOKED"
+)
+
+// Conf configures the Celery consumer
+type Conf struct {
+	BrokerAddress string `json:"brokerAddress"`
+
+	BrokerPassword string `json:"brokerPassword"`
+
+	BrokerDatabase int `json:"brokerDatabase"`
+
+	// Queues specifies Redis lists messages are read from
+	// (default is ["celery"])
+	Queues []string `json:"queues"`
+
+	// StoreResults enables writing results to
+	// celery-task-meta-[task ID] keys
+	StoreResults bool `json:"storeResults"`
+
+	// ResultExpiresSeconds specifies how long stored
+	// results are kept (default is one day)
+	ResultExpiresSeconds int `json:"resultExpiresSeconds"`
+
+	PollTimeoutSeconds int `json:"pollTimeoutSeconds"`
+}
+
+// TaskMaster is a subset of workpool.Master
+// functions the consumer needs
+type TaskMaster interface {
+	GetTask(taskID string) *workpool.Task
+	SendTask(name string, jsonArgs []byte, opts *workpool.TaskOptions) (*workpool.Task, error)
+	Subscribe(taskID string, ch chan *workpool.Task)
+	Unsubscribe(taskID string, ch chan *workpool.Task)
+	OpenResultFile(taskID string) (*os.File, error)
+}
+
+// resultMeta is a task result in the format
+// of Celery's Redis result backend
+type resultMeta struct {
+	Status    string        `json:"status"`
+	Result    interface{}   `json:"result"`
+	Traceback *string       `json:"traceback"`
+	Children  []interface{} `json:"children"`
+	DateDone  string        `json:"date_done"`
+	TaskID    string        `json:"task_id"`
+}
+
+// exceptionInfo describes a failure the way
+// Celery serializes exceptions
+type exceptionInfo struct {
+	ExcType    string   `json:"exc_type"`
+	ExcMessage []string `json:"exc_message"`
+	ExcModule  string   `json:"exc_module"`
+}
+
+// Consumer fetches Celery messages from a broker
+// and sends them as tasks to Master
+type Consumer struct {
+	conf    *Conf
+	broker  Broker
+	master  TaskMaster
+	stop    chan bool
+	wg      *sync.WaitGroup
+	mutex   *sync.Mutex
+	watched map[string]string // Celery task ID => ID of the watched task
+}
+
+// NewConsumer is a default factory for Consumer
+func NewConsumer(conf *Conf, broker Broker, master TaskMaster) *Consumer {
+	return &Consumer{
+		conf:    conf,
+		broker:  broker,
+		master:  master,
+		stop:    make(chan bool),
+		wg:      &sync.WaitGroup{},
+		mutex:   &sync.Mutex{},
+		watched: make(map[string]string),
+	}
+}
+
+func (c *Consumer) queues() []string {
+	if len(c.conf.Queues) == 0 {
+		return []string{defaultQueue}
+	}
+	return c.conf.Queues
+}
+
+func (c *Consumer) pollTimeout() time.Duration {
+	if c.conf.PollTimeoutSeconds > 0 {
+		return time.Duration(c.conf.PollTimeoutSeconds) * time.Second
+	}
+	return defaultPollTimeoutSeconds * time.Second
+}
+
+func (c *Consumer) resultExpiration() time.Duration {
+	if c.conf.ResultExpiresSeconds > 0 {
+		return time.Duration(c.conf.ResultExpiresSeconds) * time.Second
+	}
+	return defaultResultExpiresSeconds * time.Second
+}
+
+// sleep waits for a specified time or until the consumer
+// is stopped. The returned value is false in the latter case.
+func (c *Consumer) sleep(d time.Duration) bool {
+	select {
+	case <-c.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Start returns unacknowledged messages left by a previous
+// run back to their queues and starts to consume messages.
+// The function is non-blocking.
+func (c *Consumer) Start() {
+	for _, queue := range c.queues() {
+		unacked := unackedKeyPrefix + queue
+		n, err := c.broker.Recover(queue, unacked)
+		if err != nil {
+			log.Printf("ERROR: failed to recover unacknowledged Celery messages of %s: %s", queue, err)
+
+		} else if n > 0 {
+			log.Printf("INFO: returned %d unacknowledged Celery message(s) to %s", n, queue)
+		}
+		c.wg.Add(1)
+		go c.consume(queue, unacked)
+	}
+	log.Printf("INFO: consuming Celery messages from %s", strings.Join(c.queues(), ", "))
+}
+
+// Stop stops fetching messages and watching task results
+// and waits for all the consumer's goroutines to finish.
+// Tasks already sent to Master are not affected.
+func (c *Consumer) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+// WatchedTasks returns Celery task IDs with results not stored
+// yet mapped to IDs of the respective watched tasks. A new
+// consumer (e.g. after reload) can take them over via Watch.
+func (c *Consumer) WatchedTasks() map[string]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ans := make(map[string]string)
+	for callID, taskID := range c.watched {
+		ans[callID] = taskID
+	}
+	return ans
+}
+
+func (c *Consumer) consume(queue string, unacked string) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		msg, err := c.broker.Fetch(queue, unacked, c.pollTimeout())
+		if err != nil {
+			log.Printf("ERROR: failed to fetch Celery message from %s: %s", queue, err)
+			if !c.sleep(errorDelay) {
+				return
+			}
+			continue
+		}
+		if msg != "" && !c.process(queue, unacked, msg) {
+			if !c.sleep(requeueDelay) {
+				return
+			}
+		}
+	}
+}
+
+// process sends a message to Master as a task. The returned
+// value is false in case the message has been returned to its
+// queue to be processed later.
+func (c *Consumer) process(queue string, unacked string, msg string) bool {
+	call, err := parseMessage(msg)
+	if err != nil {
+		log.Printf("ERROR: invalid Celery message in %s, dropping: %s", queue, err)
+		if call != nil {
+			c.storeFailure(call.ID, err.Error())
+		}
+		c.ack(unacked, msg)
+		return true
+	}
+	if err := workpool.ValidateTaskID(call.ID); err != nil {
+		log.Printf("ERROR: Celery task %s rejected: %s", call.Task, err)
+		c.storeFailure(call.ID, err.Error())
+		c.ack(unacked, msg)
+		return true
+	}
+	if call.IsExpired(time.Now()) {
+		log.Printf("WARNING: Celery task %s (%s) expired, revoking", call.ID, call.Task)
+		c.storeResult(call.ID, stateRevoked, nil, nil)
+		c.ack(unacked, msg)
+		return true
+	}
+	args, err := json.Marshal(call.WorkerArgs())
+	if err != nil {
+		log.Printf("ERROR: failed to encode arguments of Celery task %s: %s", call.ID, err)
+		c.storeFailure(call.ID, err.Error())
+		c.ack(unacked, msg)
+		return true
+	}
+	task, err := c.master.SendTask(call.Task, args, &workpool.TaskOptions{
+		TaskID:    call.ID,
+		ETA:       call.ETA,
+		Submitter: "celery",
+	})
+	if _, ok := err.(*workpool.QueueFullError); ok || err == workpool.ErrDraining {
+		log.Printf("WARNING: Celery task %s postponed: %s", call.ID, err)
+		if err := c.broker.Requeue(queue, unacked, msg); err != nil {
+			log.Printf("ERROR: failed to requeue Celery message %s: %s", call.ID, err)
+		}
+		return false
+
+	} else if err != nil {
+		log.Printf("ERROR: Celery task %s (%s) rejected: %s", call.ID, call.Task, err)
+		c.storeFailure(call.ID, err.Error())
+		c.ack(unacked, msg)
+		return true
+	}
+	if task.TaskID != call.ID {
+		// e.g. a duplicate of an existing task - the client
+		// still expects the result under its own task ID
+		log.Printf("INFO: Celery task %s (%s) accepted as %s", call.ID, task.Fn, task.TaskID)
+
+	} else {
+		log.Printf("INFO: Celery task %s (%s) accepted", call.ID, task.Fn)
+	}
+	c.ack(unacked, msg)
+	c.Watch(call.ID, task.TaskID)
+	return true
+}
+
+func (c *Consumer) ack(unacked string, msg string) {
+	if err := c.broker.Ack(unacked, msg); err != nil {
+		log.Print("ERROR: failed to acknowledge Celery message: ", err)
+	}
+}
+
+// Watch waits (in a separate goroutine) for a task to finish
+// and stores its result as a result of a Celery task callID
+// (the IDs differ e.g. in case the Celery task has been
+// deduplicated). If results storing is disabled, nothing is done.
+func (c *Consumer) Watch(callID string, taskID string) {
+	if !c.conf.StoreResults {
+		return
+	}
+	c.mutex.Lock()
+	if _, ok := c.watched[callID]; ok {
+		c.mutex.Unlock()
+		return
+	}
+	c.watched[callID] = taskID
+	c.mutex.Unlock()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ch := make(chan *workpool.Task, 10)
+		c.master.Subscribe(taskID, ch)
+		defer c.master.Unsubscribe(taskID, ch)
+		for {
+			var task *workpool.Task
+			select {
+			case <-c.stop:
+				return
+			case task = <-ch:
+			case <-time.After(resultCheckInterval):
+				task = c.master.GetTask(taskID)
+				if task == nil {
+					log.Printf("WARNING: task %s not found, result of Celery task %s will not be stored",
+						taskID, callID)
+					c.unwatch(callID)
+					return
+				}
+			}
+			if task.IsDone() {
+				c.storeTaskResult(callID, task)
+				c.unwatch(callID)
+				return
+			}
+		}
+	}()
+}
+
+func (c *Consumer) unwatch(callID string) {
+	c.mutex.Lock()
+	delete(c.watched, callID)
+	c.mutex.Unlock()
+}
+
+// taskResult returns a result of a finished task
+// (including results passed via files)
+func (c *Consumer) taskResult(task *workpool.Task) (interface{}, error) {
+	if !task.ResultOffloaded {
+		return task.Result, nil
+	}
+	file, err := c.master.OpenResultFile(task.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// storeTaskResult stores a result of a finished task
+// as a result of a Celery task callID
+func (c *Consumer) storeTaskResult(callID string, task *workpool.Task) {
+	if task.IsCancelled() {
+		c.storeResult(callID, stateRevoked, nil, nil)
+		return
+	}
+	if task.Error != "" {
+		var traceback *string
+		if task.ErrorDetail != nil && len(task.ErrorDetail.Traceback) > 0 {
+			tb := strings.Join(task.ErrorDetail.Traceback, "\n")
+			traceback = &tb
+		}
+		c.storeResult(callID, stateFailure, exceptionResult(task.Error), traceback)
+		return
+	}
+	result, err := c.taskResult(task)
+	if err != nil {
+		log.Printf("ERROR: failed to read result of task %s: %s", task.TaskID, err)
+		c.storeFailure(callID, err.Error())
+		return
+	}
+	c.storeResult(callID, stateSuccess, result, nil)
+}
+
+func exceptionResult(msg string) *exceptionInfo {
+	return &exceptionInfo{
+		ExcType:    "Exception",
+		ExcMessage: []string{msg},
+		ExcModule:  "builtins",
+	}
+}
+
+func (c *Consumer) storeFailure(taskID string, msg string) {
+	c.storeResult(taskID, stateFailure, exceptionResult(msg), nil)
+}
+
+func (c *Consumer) storeResult(taskID string, state string, result interface{}, traceback *string) {
+	if !c.conf.StoreResults {
+		return
+	}
+	data, err := json.Marshal(resultMeta{
+		Status:    state,
+		Result:    result,
+		Traceback: traceback,
+		Children:  []interface{}{},
+		DateDone:  time.Now().UTC().Format("2006-01-02T15:04:05.000000"),
+		TaskID:    taskID,
+	})
+	if err != nil {
+		log.Printf("ERROR: failed to encode result of Celery task %s: %s", taskID, err)
+		return
+	}
+	if err := c.broker.StoreResult(resultKeyPrefix+taskID, data, c.resultExpiration()); err != nil {
+		log.Printf("ERROR: failed to store result of Celery task %s: %s", taskID, err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package celery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/czcorpus/konserver/workpool"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBroker is an in-memory stand-in for Redis
+type fakeBroker struct {
+	mutex   *sync.Mutex
+	lists   map[string][]string // the first item is the list's head
+	results map[string][]byte
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		mutex:   &sync.Mutex{},
+		lists:   make(map[string][]string),
+		results: make(map[string][]byte),
+	}
+}
+
+func (fb *fakeBroker) list(key string) []string {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	return append([]string{}, fb.lists[key]...)
+}
+
+func (fb *fakeBroker) result(key string) []byte {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	return fb.results[key]
+}
+
+// push adds a message the same way kombu does (LPUSH)
+func (fb *fakeBroker) push(queue string, msg string) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.lists[queue] = append([]string{msg}, fb.lists[queue]...)
+}
+
+func (fb *fakeBroker) Fetch(queue string, unacked string, timeout time.Duration) (string, error) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	items := fb.lists[queue]
+	if len(items) == 0 {
+		fb.mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		fb.mutex.Lock()
+		return "", nil
+	}
+	msg := items[len(items)-1]
+	fb.lists[queue] = items[:len(items)-1]
+	fb.lists[unacked] = append([]string{msg}, fb.lists[unacked]...)
+	return msg, nil
+}
+
+func (fb *fakeBroker) remove(key string, msg string) {
+	for i, item := range fb.lists[key] {
+		if item == msg {
+			fb.lists[key] = append(fb.lists[key][:i], fb.lists[key][i+1:]...)
+			return
+		}
+	}
+}
+
+func (fb *fakeBroker) Ack(unacked string, msg string) error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.remove(unacked, msg)
+	return nil
+}
+
+func (fb *fakeBroker) Requeue(queue string, unacked string, msg string) error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.remove(unacked, msg)
+	fb.lists[queue] = append(fb.lists[queue], msg)
+	return nil
+}
+
+func (fb *fakeBroker) Recover(queue string, unacked string) (int, error) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	msgs := fb.lists[unacked]
+	fb.lists[queue] = append(fb.lists[queue], msgs...)
+	delete(fb.lists, unacked)
+	return len(msgs), nil
+}
+
+func (fb *fakeBroker) StoreResult(key string, value []byte, expiration time.Duration) error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	fb.results[key] = value
+	return nil
+}
+
+// ------------------------------------------------------------
+
+type fakeMaster struct {
+	mutex       *sync.Mutex
+	tasks       map[string]*workpool.Task
+	subscribers map[string]chan *workpool.Task
+	sendErr     error
+	sent        chan *workpool.Task
+}
+
+func newFakeMaster() *fakeMaster {
+	return &fakeMaster{
+		mutex:       &sync.Mutex{},
+		tasks:       make(map[string]*workpool.Task),
+		subscribers: make(map[string]chan *workpool.Task),
+		sent:        make(chan *workpool.Task, 10),
+	}
+}
+
+func (fm *fakeMaster) GetTask(taskID string) *workpool.Task {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	return fm.tasks[taskID]
+}
+
+func (fm *fakeMaster) SendTask(name string, jsonArgs []byte, opts *workpool.TaskOptions) (*workpool.Task, error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	if fm.sendErr != nil {
+		return nil, fm.sendErr
+	}
+	var args interface{}
+	json.Unmarshal(jsonArgs, &args)
+	task := &workpool.Task{TaskID: opts.TaskID, Fn: name, Args: args}
+	fm.tasks[task.TaskID] = task
+	fm.sent <- task
+	return task, nil
+}
+
+func (fm *fakeMaster) Subscribe(taskID string, ch chan *workpool.Task) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	fm.subscribers[taskID] = ch
+	// the same as workpool.Master - the current state is sent immediately
+	if task, ok := fm.tasks[taskID]; ok {
+		ch <- task
+	}
+}
+
+func (fm *fakeMaster) Unsubscribe(taskID string, ch chan *workpool.Task) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	delete(fm.subscribers, taskID)
+}
+
+func (fm *fakeMaster) OpenResultFile(taskID string) (*os.File, error) {
+	return nil, fmt.Errorf("no result file")
+}
+
+func (fm *fakeMaster) finish(taskID string, result interface{}, errMsg string) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	task := *fm.tasks[taskID]
+	task.Result = result
+	task.Error = errMsg
+	task.Status = 2 // finished
+	if errMsg != "" {
+		task.Status = 5 // failed
+	}
+	fm.tasks[taskID] = &task
+	if ch, ok := fm.subscribers[taskID]; ok {
+		ch <- &task
+	}
+}
+
+func waitForSent(t *testing.T, fm *fakeMaster) *workpool.Task {
+	select {
+	case task := <-fm.sent:
+		return task
+	case <-time.After(time.Second):
+		t.Fatal("task not sent to master")
+		return nil
+	}
+}
+
+func waitForAck(t *testing.T, fb *fakeBroker) {
+	for i := 0; i < 100; i++ {
+		if len(fb.list(unackedKeyPrefix+defaultQueue)) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("message not acknowledged")
+}
+
+func waitForResult(t *testing.T, fb *fakeBroker, taskID string) *resultMeta {
+	for i := 0; i < 300; i++ {
+		if data := fb.result(resultKeyPrefix + taskID); data != nil {
+			var ans resultMeta
+			assert.Nil(t, json.Unmarshal(data, &ans))
+			return &ans
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("result not stored")
+	return nil
+}
+
+func TestConsumerExecutesTask(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fb.push(defaultQueue, kombuMessage("t1", "worker.sum", nil, map[string]interface{}{"a": 1.0}, nil))
+	consumer := NewConsumer(&Conf{StoreResults: true}, fb, fm)
+	consumer.Start()
+	defer consumer.Stop()
+
+	task := waitForSent(t, fm)
+	assert.Equal(t, "t1", task.TaskID)
+	assert.Equal(t, "worker.sum", task.Fn)
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, task.Args)
+	waitForAck(t, fb)
+	assert.Empty(t, fb.list(defaultQueue))
+
+	fm.finish("t1", 42, "")
+	meta := waitForResult(t, fb, "t1")
+	assert.Equal(t, stateSuccess, meta.Status)
+	assert.Equal(t, 42.0, meta.Result)
+	assert.Equal(t, "t1", meta.TaskID)
+}
+
+func TestConsumerStoresFailure(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fb.push(defaultQueue, kombuMessage("t1", "worker.sum", nil, nil, nil))
+	consumer := NewConsumer(&Conf{StoreResults: true}, fb, fm)
+	consumer.Start()
+	defer consumer.Stop()
+
+	waitForSent(t, fm)
+	fm.finish("t1", nil, "division by zero")
+	meta := waitForResult(t, fb, "t1")
+	assert.Equal(t, stateFailure, meta.Status)
+	assert.Equal(t, map[string]interface{}{
+		"exc_type": "Exception", "exc_message": []interface{}{"division by zero"}, "exc_module": "builtins",
+	}, meta.Result)
+}
+
+func TestConsumerRejectsTask(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fm.sendErr = &workpool.UnknownFunctionError{Fn: "foo", Pool: "default"}
+	fb.push(defaultQueue, kombuMessage("t1", "foo", nil, nil, nil))
+	consumer := NewConsumer(&Conf{StoreResults: true}, fb, fm)
+	consumer.Start()
+	defer consumer.Stop()
+
+	meta := waitForResult(t, fb, "t1")
+	assert.Equal(t, stateFailure, meta.Status)
+	waitForAck(t, fb)
+}
+
+func TestConsumerRejectsInvalidTaskID(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fb.push(defaultQueue, kombuMessage("../escaped", "worker.sum", nil, nil, nil))
+	consumer := NewConsumer(&Conf{StoreResults: true}, fb, fm)
+	consumer.Start()
+	defer consumer.Stop()
+
+	meta := waitForResult(t, fb, "../escaped")
+	assert.Equal(t, stateFailure, meta.Status)
+	waitForAck(t, fb)
+	assert.Empty(t, fb.list(defaultQueue))
+	assert.Empty(t, fm.sent)
+}
+
+func TestConsumerRequeuesWhenDraining(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fm.sendErr = workpool.ErrDraining
+	msg := kombuMessage("t1", "worker.sum", nil, nil, nil)
+	fb.push(defaultQueue, msg)
+	consumer := NewConsumer(&Conf{}, fb, fm)
+	consumer.Start()
+	time.Sleep(100 * time.Millisecond)
+	consumer.Stop()
+	assert.Equal(t, []string{msg}, fb.list(defaultQueue))
+	assert.Empty(t, fb.list(unackedKeyPrefix+defaultQueue))
+}
+
+func TestConsumerRecoversUnacked(t *testing.T) {
+	fb := newFakeBroker()
+	fm := newFakeMaster()
+	fb.push(unackedKeyPrefix+defaultQueue, kombuMessage("t1", "worker.sum", nil, nil, nil))
+	consumer := NewConsumer(&Conf{}, fb, fm)
+	consumer.Start()
+	defer consumer.Stop()
+	assert.Equal(t, "t1", waitForSent(t, fm).TaskID)
+}
+
+func TestConsumerStoresResultOfDeduplicatedTask(t *testing.T) {
+	master := workpool.NewMaster(&workpool.MasterConf{
+		PoolSize:                   1,
+		Program:                    "sh",
+		ProgramArgs:                []string{"-c", `while read line; do sleep 0.3; echo '{"status": 0, "result": 42}'; done`},
+		ExecMaxSeconds:             10,
+		DeduplicateByArgs:          true,
+		DeduplicationWindowSeconds: 60,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, workpool.NewMemoryTaskStore())
+	master.Start()
+	defer master.Stop()
+	fb := newFakeBroker()
+	kwargs := map[string]interface{}{"a": 1.0}
+	fb.push(defaultQueue, kombuMessage("id-1", "worker.sum", nil, kwargs, nil))
+	fb.push(defaultQueue, kombuMessage("id-2", "worker.sum", nil, kwargs, nil))
+	consumer := NewConsumer(&Conf{StoreResults: true}, fb, master)
+	consumer.Start()
+	defer consumer.Stop()
+
+	for _, callID := range []string{"id-1", "id-2"} {
+		meta := waitForResult(t, fb, callID)
+		assert.Equal(t, stateSuccess, meta.Status)
+		assert.Equal(t, 42.0, meta.Result)
+		assert.Equal(t, callID, meta.TaskID)
+	}
+	assert.Nil(t, master.GetTask("id-2"))
+}
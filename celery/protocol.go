@@ -0,0 +1,164 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package celery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeJSON = "application/json"
+
+	// celeryNaiveTimeLayout is used by Celery for times
+	// without time zone (which are in UTC)
+	celeryNaiveTimeLayout = "2006-01-02T15:04:05.999999999"
+)
+
+// Message is a Celery message as stored by kombu's
+// Redis transport (an envelope around the task body)
+type Message struct {
+	Body            string            `json:"body"`
+	ContentEncoding string            `json:"content-encoding"`
+	ContentType     string            `json:"content-type"`
+	Headers         MessageHeaders    `json:"headers"`
+	Properties      MessageProperties `json:"properties"`
+}
+
+// MessageHeaders contains task metadata
+// (Celery protocol v2 only)
+type MessageHeaders struct {
+	Lang     string  `json:"lang"`
+	Task     string  `json:"task"`
+	ID       string  `json:"id"`
+	ETA      *string `json:"eta"`
+	Expires  *string `json:"expires"`
+	Retries  int     `json:"retries"`
+	RootID   *string `json:"root_id"`
+	ParentID *string `json:"parent_id"`
+}
+
+// MessageProperties contains delivery information
+type MessageProperties struct {
+	CorrelationID string `json:"correlation_id"`
+	ReplyTo       string `json:"reply_to"`
+	BodyEncoding  string `json:"body_encoding"`
+	DeliveryTag   string `json:"delivery_tag"`
+	Priority      int    `json:"priority"`
+}
+
+// TaskCall is a decoded task invocation
+type TaskCall struct {
+	ID      string
+	Task    string
+	Args    []interface{}
+	Kwargs  map[string]interface{}
+	ETA     time.Time
+	Expires time.Time
+}
+
+// WorkerArgs returns arguments in a form passed to konserver
+// workers: keyword arguments (an object) in case there are
+// no positional ones, positional arguments (a list) in case
+// there are no keyword ones. Otherwise, an object with "args"
+// and "kwargs" keys is returned.
+func (tc *TaskCall) WorkerArgs() interface{} {
+	if len(tc.Args) == 0 {
+		if tc.Kwargs == nil {
+			return map[string]interface{}{}
+		}
+		return tc.Kwargs
+	}
+	if len(tc.Kwargs) == 0 {
+		return tc.Args
+	}
+	return map[string]interface{}{
+		"args":   tc.Args,
+		"kwargs": tc.Kwargs,
+	}
+}
+
+// IsExpired tests whether the task should not be
+// executed anymore
+func (tc *TaskCall) IsExpired(now time.Time) bool {
+	return !tc.Expires.IsZero() && now.After(tc.Expires)
+}
+
+// parseCeleryTime parses time as formatted by Celery
+// (ISO 8601 with or without time zone)
+func parseCeleryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(celeryNaiveTimeLayout, s, time.UTC)
+}
+
+// parseMessage decodes a raw message obtained from the broker.
+// Only JSON-serialized messages of protocol v2 are supported.
+// In case the message can be decoded at least partially, the
+// returned TaskCall contains the task ID so a failure can be
+// reported to the client.
+func parseMessage(data string) (*TaskCall, error) {
+	var msg Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, fmt.Errorf("invalid message envelope: %s", err)
+	}
+	ans := &TaskCall{ID: msg.Headers.ID, Task: msg.Headers.Task}
+	if ans.ID == "" || ans.Task == "" {
+		return nil, fmt.Errorf("unsupported message (protocol v2 task headers missing)")
+	}
+	if !strings.HasPrefix(msg.ContentType, contentTypeJSON) {
+		return ans, fmt.Errorf("unsupported content type %s (JSON serializer required)", msg.ContentType)
+	}
+	body := []byte(msg.Body)
+	if msg.Properties.BodyEncoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(msg.Body)
+		if err != nil {
+			return ans, fmt.Errorf("invalid body encoding: %s", err)
+		}
+	}
+	// body is [args, kwargs, embed]
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil || len(items) < 2 {
+		return ans, fmt.Errorf("invalid message body")
+	}
+	if err := json.Unmarshal(items[0], &ans.Args); err != nil {
+		return ans, fmt.Errorf("invalid positional arguments: %s", err)
+	}
+	if err := json.Unmarshal(items[1], &ans.Kwargs); err != nil {
+		return ans, fmt.Errorf("invalid keyword arguments: %s", err)
+	}
+	if msg.Headers.ETA != nil {
+		eta, err := parseCeleryTime(*msg.Headers.ETA)
+		if err != nil {
+			return ans, fmt.Errorf("invalid eta %s", *msg.Headers.ETA)
+		}
+		ans.ETA = eta
+	}
+	if msg.Headers.Expires != nil {
+		expires, err := parseCeleryTime(*msg.Headers.Expires)
+		if err != nil {
+			return ans, fmt.Errorf("invalid expires %s", *msg.Headers.Expires)
+		}
+		ans.Expires = expires
+	}
+	return ans, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package celery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kombuMessage creates a message the same way Celery's
+// Redis transport does
+func kombuMessage(taskID string, fn string, args []interface{}, kwargs map[string]interface{},
+	eta interface{}) string {
+	body, _ := json.Marshal([]interface{}{args, kwargs, map[string]interface{}{
+		"callbacks": nil, "errbacks": nil, "chain": nil, "chord": nil}})
+	msg, _ := json.Marshal(map[string]interface{}{
+		"body":             base64.StdEncoding.EncodeToString(body),
+		"content-encoding": "utf-8",
+		"content-type":     "application/json",
+		"headers": map[string]interface{}{
+			"lang": "py", "task": fn, "id": taskID, "eta": eta, "expires": nil,
+			"retries": 0, "root_id": taskID, "parent_id": nil,
+		},
+		"properties": map[string]interface{}{
+			"correlation_id": taskID, "reply_to": "a1b2", "delivery_mode": 2,
+			"delivery_info": map[string]interface{}{"exchange": "", "routing_key": "celery"},
+			"priority":      0, "body_encoding": "base64", "delivery_tag": "d1",
+		},
+	})
+	return string(msg)
+}
+
+func TestParseMessage(t *testing.T) {
+	msg := kombuMessage("t1", "worker.conc_register", []interface{}{1, "x"},
+		map[string]interface{}{"a": true}, "2026-10-17T05:21:17.5+00:00")
+	call, err := parseMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", call.ID)
+	assert.Equal(t, "worker.conc_register", call.Task)
+	assert.Equal(t, []interface{}{1.0, "x"}, call.Args)
+	assert.Equal(t, map[string]interface{}{"a": true}, call.Kwargs)
+	assert.True(t, call.ETA.Equal(time.Date(2026, 10, 17, 5, 21, 17, 500000000, time.UTC)))
+	assert.True(t, call.Expires.IsZero())
+}
+
+func TestParseMessageNaiveTime(t *testing.T) {
+	call, err := parseMessage(kombuMessage("t1", "f", []interface{}{}, nil, "2026-10-17T05:21:17.583831"))
+	assert.Nil(t, err)
+	assert.True(t, call.ETA.Equal(time.Date(2026, 10, 17, 5, 21, 17, 583831000, time.UTC)))
+}
+
+func TestParseMessageUnsupported(t *testing.T) {
+	_, err := parseMessage(`{"body": "x", "headers": {}}`) // protocol v1
+	assert.Error(t, err)
+	var msg map[string]interface{}
+	json.Unmarshal([]byte(kombuMessage("t1", "f", []interface{}{}, nil, nil)), &msg)
+	msg["content-type"] = "application/x-python-serialize"
+	data, _ := json.Marshal(msg)
+	call, err := parseMessage(string(data))
+	assert.Error(t, err)
+	assert.Equal(t, "t1", call.ID)
+}
+
+func TestWorkerArgs(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{}, (&TaskCall{}).WorkerArgs())
+	assert.Equal(t, []interface{}{1}, (&TaskCall{Args: []interface{}{1}}).WorkerArgs())
+	kwargs := map[string]interface{}{"a": 1}
+	assert.Equal(t, kwargs, (&TaskCall{Kwargs: kwargs}).WorkerArgs())
+	assert.Equal(t, map[string]interface{}{"args": []interface{}{1}, "kwargs": kwargs},
+		(&TaskCall{Args: []interface{}{1}, Kwargs: kwargs}).WorkerArgs())
+}
@@ -19,8 +19,10 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
+	"path/filepath"
 
 	"github.com/czcorpus/konserver/apiserver"
+	"github.com/czcorpus/konserver/celery"
 	"github.com/czcorpus/konserver/taskdb"
 	"github.com/czcorpus/konserver/workpool"
 )
@@ -33,13 +35,17 @@ type AppConfig struct {
 	CacheRootDir    string                 `json:"cacheRootDir"`
 	WorkerMaster    workpool.MasterConf    `json:"workerMaster"`
 	LogPath         string                 `json:"logPath"`
+
+	// Celery configures consuming of Celery messages
+	// (nil = disabled). It requires the task queue mode.
+	Celery *celery.Conf `json:"celery"`
 }
 
 // ConfiguresQueue tests whether the application
 // is configured to run in "task queue" mode
 // (i.e. as a Celery replacement).
 func (ac *AppConfig) ConfiguresQueue() bool {
-	return ac.WorkerMaster.PoolSize > 0
+	return ac.WorkerMaster.HasWorkers()
 }
 
 func loadConfig(path string) (*AppConfig, error) {
@@ -52,5 +58,8 @@ func loadConfig(path string) (*AppConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if conf.WorkerMaster.ResultFilesDir == "" && conf.CacheRootDir != "" {
+		conf.WorkerMaster.ResultFilesDir = filepath.Join(conf.CacheRootDir, "konserver-results")
+	}
 	return &conf, nil
 }
@@ -24,6 +24,7 @@ import (
 	"syscall"
 
 	"github.com/czcorpus/konserver/apiserver"
+	"github.com/czcorpus/konserver/celery"
 	"github.com/czcorpus/konserver/taskdb"
 	"github.com/czcorpus/konserver/workpool"
 	"github.com/czcorpus/konserver/workpool/nullqueue"
@@ -31,9 +32,18 @@ import (
 
 func main() {
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGHUP)
+	signal.Notify(sc, syscall.SIGHUP, syscall.SIGTERM)
 	flag.Parse()
 
+	// task store is shared between reloads so tasks
+	// (and their results) survive the reload
+	var taskStore workpool.TaskStore
+	var taskStoreDir string
+	// pending tasks passed from a drained master to a new one
+	var handover *workpool.Handover
+	// Celery tasks with results not stored yet
+	var celeryTasks map[string]string
+
 	for {
 		conf, err := loadConfig(flag.Arg(0))
 		if err != nil {
@@ -48,24 +58,68 @@ func main() {
 		}
 
 		cacheDB := taskdb.NewConcCacheDB(&conf.Redis)
-		hub := apiserver.NewHub(cacheDB)
 		var taskMaster apiserver.TaskMaster
-		if conf.WorkerMaster.PoolSize > 0 {
-			taskMaster = workpool.NewMaster(&conf.WorkerMaster)
+		if conf.ConfiguresQueue() {
+			if taskStore == nil || taskStoreDir != conf.WorkerMaster.TaskStoreDir {
+				taskStore, err = workpool.NewTaskStore(&conf.WorkerMaster)
+				if err != nil {
+					log.Fatal("ERROR: Failed to open task store: ", err)
+				}
+				taskStoreDir = conf.WorkerMaster.TaskStoreDir
+			}
+			taskMaster = workpool.NewMaster(&conf.WorkerMaster, taskStore)
 
 		} else {
 			taskMaster = &nullqueue.NullQueue{}
 		}
+		hub := apiserver.NewHub(cacheDB, taskMaster)
 		server := apiserver.NewAPIServer(hub, &conf.APIServerConfig, taskMaster, conf.CacheRootDir)
 
+		taskMaster.Adopt(handover)
+
+		var celeryConsumer *celery.Consumer
+		if conf.Celery != nil && conf.ConfiguresQueue() {
+			celeryConsumer = celery.NewConsumer(conf.Celery, celery.NewRedisBroker(conf.Celery), taskMaster)
+			for callID, taskID := range celeryTasks {
+				celeryConsumer.Watch(callID, taskID)
+			}
+
+		} else if conf.Celery != nil {
+			log.Print("WARNING: Celery consumer requires a worker pool, ignoring")
+		}
+
+		// the master must be started before anything
+		// can submit tasks (it is non-blocking)
+		taskMaster.Start()
 		go hub.Start()
 		go server.Start()
-		go taskMaster.Start()
+		if celeryConsumer != nil {
+			celeryConsumer.Start()
+		}
+
+		sig := <-sc
+		if sig == syscall.SIGTERM {
+			log.Print("Shutting down services...")
 
-		<-sc
-		log.Print("Reloading services...")
+		} else {
+			log.Print("Reloading services...")
+		}
+		if celeryConsumer != nil {
+			celeryConsumer.Stop()
+			celeryTasks = celeryConsumer.WatchedTasks()
+		}
+		// the API server keeps running during drain so clients
+		// can still obtain results and status notifications
+		handover = taskMaster.Drain(conf.WorkerMaster.DrainGrace())
 		server.Stop()
 		hub.Stop()
 		taskMaster.Stop()
+		if sig == syscall.SIGTERM {
+			if handover != nil && len(handover.Tasks) > 0 && conf.WorkerMaster.TaskStoreDir == "" &&
+				conf.WorkerMaster.JournalPath == "" {
+				log.Printf("WARNING: %d pending task(s) lost (no taskStoreDir or journalPath configured)", len(handover.Tasks))
+			}
+			return
+		}
 	}
 }
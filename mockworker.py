@@ -3,6 +3,17 @@ import json
 import sys
 import random
 import os
+import signal
+import tempfile
+import traceback
+
+
+class SoftTimeLimitExceeded(Exception):
+    pass
+
+
+def soft_time_limit_handler(signum, frame):
+    raise SoftTimeLimitExceeded('soft time limit exceeded')
 
 class Random(object):
 
@@ -17,9 +28,30 @@ class Random(object):
 
 ran = Random([1, 2, 3, 4, 5, 6, 7, 2, 3, 4, 5, 1, 2, 4, 8, 1, 5, 2, 3, 7, 3, 2, 8])
 
+FUNCTIONS = [
+    dict(name='worker.conc_register'),
+    dict(name='worker.calculate_freqs'),
+    dict(name='worker.sum_and_repeat', argsSchema=dict(
+        type='object',
+        required=['word', 'a', 'b'],
+        properties=dict(word=dict(type='string'), a=dict(type='integer'), b=dict(type='integer'))))
+]
+
+
+def send_hello():
+    sys.stdout.write(json.dumps(dict(control='hello', functions=FUNCTIONS)) + '\n')
+    sys.stdout.flush()
+
+
+def report_progress(percent, message):
+    sys.stdout.write(json.dumps(dict(status=1, progress=dict(percent=percent, message=message))) + '\n')
+    sys.stdout.flush()
+
 
 def perform_task(command):
+    report_progress(0, 'started')
     time.sleep(ran.next())
+    report_progress(90, 'almost done')
     if command['fn'] == 'worker.conc_register':
         ans = dict(
             cachefile='/var/local/corpora/cache/syn2015/foobac.conc',
@@ -29,15 +61,23 @@ def perform_task(command):
             error=None,
             result=ans
         )
+    elif command['fn'] == 'worker.calculate_freqs':
+        # large results are passed via a file
+        fd, path = tempfile.mkstemp(suffix='.json')
+        with os.fdopen(fd, 'w') as fw:
+            json.dump(dict(data=[['word%d' % i, ran.next()] for i in range(10000)]), fw)
+        return dict(status=2, error=None, resultFile=path)
     elif command['fn'] == 'worker.sum_and_repeat':
         args = command['args']
         return dict(status=2, error=None, result=[args['word']] * (args['a'] + args['b']))
     else:
-        return dict(status=2, error=None, result=dict(some_data={}))
+        raise ValueError('unknown function {0}'.format(command['fn']))
 
 
 if __name__ == '__main__':
     ident = os.getpid()
+    signal.signal(signal.SIGUSR1, soft_time_limit_handler)
+    send_hello()
     with open('/tmp/mockworker.txt', 'ab') as fw:
         fw.write('>>>>>>>>>>>>>>> INIT <<<<<<<<<<<<<<<<<<<<<<<\n')
         fw.flush()
@@ -47,14 +87,19 @@ if __name__ == '__main__':
             fw.flush()
             if command == '':
                 break
+            if json.loads(command).get('control') == 'ping':
+                sys.stdout.write(json.dumps(dict(control='pong')) + '\n')
+                sys.stdout.flush()
+                continue
             try:
                 ans = perform_task(json.loads(command))
                 fw.write('ANS: %s\n' % (ans,))
                 sys.stdout.write(json.dumps(ans) + '\n')
             except Exception as ex:
                 msg = '{0}: {1}'.format(ex.__class__.__name__, ex)
-                fw.write('ANS: {0}\n'.format(json.dumps(dict(status=2, error=msg))))
-                sys.stdout.write(json.dumps(dict(status=2, error=msg)) + '\n')
+                ans = dict(status=2, error=msg, traceback=traceback.format_exc().splitlines())
+                fw.write('ANS: {0}\n'.format(json.dumps(ans)))
+                sys.stdout.write(json.dumps(ans) + '\n')
             fw.flush()
             sys.stdout.flush()
 
@@ -0,0 +1,301 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	beatTimeFormat = "2006-01-02 15:04:05"
+)
+
+// PeriodicTaskConf describes a task submitted
+// repeatedly by the built-in scheduler. Exactly
+// one of Cron and IntervalSeconds should be set.
+type PeriodicTaskConf struct {
+	Name string `json:"name"`
+
+	Fn string `json:"fn"`
+
+	Args json.RawMessage `json:"args"`
+
+	// Cron is a standard five-field cron expression
+	// (minute, hour, day of month, month, day of week)
+	// evaluated in local time.
+	Cron string `json:"cron"`
+
+	// IntervalSeconds specifies a fixed delay between
+	// two runs. The first run is scheduled IntervalSeconds
+	// after konserver start (the schedule is preserved
+	// across reloads).
+	IntervalSeconds int `json:"intervalSeconds"`
+
+	// Queue optionally overrides the default
+	// queue routing of the task
+	Queue string `json:"queue"`
+}
+
+// PeriodicTaskInfo provides information about
+// a periodic task for the "info" page
+type PeriodicTaskInfo struct {
+	Name       string
+	Fn         string
+	Schedule   string
+	LastRun    string
+	NextRun    string
+	LastTaskID string
+	LastError  string
+}
+
+type periodicTask struct {
+	conf       PeriodicTaskConf
+	schedule   *cronSchedule
+	lastRun    time.Time
+	nextRun    time.Time
+	lastTaskID string
+	lastError  string
+}
+
+// periodicTaskState is a runtime state of a periodic
+// task passed to a new beat on reload
+type periodicTaskState struct {
+	schedule   string
+	lastRun    time.Time
+	nextRun    time.Time
+	lastTaskID string
+	lastError  string
+}
+
+// key identifies the task among the configured ones
+func (pt *periodicTask) key() string {
+	if pt.conf.Name != "" {
+		return pt.conf.Name
+	}
+	return pt.conf.Fn
+}
+
+func (pt *periodicTask) computeNextRun(now time.Time) {
+	if pt.schedule != nil {
+		pt.nextRun = pt.schedule.next(now)
+		return
+	}
+	interval := time.Duration(pt.conf.IntervalSeconds) * time.Second
+	// we count from the previous planned time to prevent drift
+	// caused by the ticker granularity
+	if !pt.nextRun.IsZero() && pt.nextRun.Add(interval).After(now) {
+		pt.nextRun = pt.nextRun.Add(interval)
+
+	} else {
+		pt.nextRun = now.Add(interval)
+	}
+}
+
+func (pt *periodicTask) scheduleDesc() string {
+	if pt.schedule != nil {
+		return pt.conf.Cron
+	}
+	return fmt.Sprintf("every %ds", pt.conf.IntervalSeconds)
+}
+
+// beat submits configured periodic tasks to Master.
+// It runs its own ticker and submits tasks via Master.SendTask
+// so periodic tasks are processed exactly the same way as
+// the ones sent by clients.
+type beat struct {
+	master *Master
+	tasks  []*periodicTask
+	mutex  *sync.Mutex
+	stop   chan bool
+	done   chan bool // closed once the submitting goroutine exits
+	closed bool
+}
+
+// newBeat creates a beat from configuration. Invalid
+// entries are logged and skipped.
+func newBeat(master *Master, conf []PeriodicTaskConf) *beat {
+	ans := &beat{
+		master: master,
+		tasks:  make([]*periodicTask, 0, len(conf)),
+		mutex:  &sync.Mutex{},
+		stop:   make(chan bool, 1),
+	}
+	for _, ptc := range conf {
+		pt := &periodicTask{conf: ptc}
+		if ptc.Fn == "" {
+			log.Printf("ERROR: periodic task %s has no fn, skipping", ptc.Name)
+			continue
+		}
+		if ptc.Cron != "" {
+			schedule, err := parseCron(ptc.Cron)
+			if err != nil {
+				log.Printf("ERROR: periodic task %s has invalid cron expression: %s, skipping", ptc.Name, err)
+				continue
+			}
+			pt.schedule = schedule
+
+		} else if ptc.IntervalSeconds <= 0 {
+			log.Printf("ERROR: periodic task %s has neither cron nor interval, skipping", ptc.Name)
+			continue
+		}
+		ans.tasks = append(ans.tasks, pt)
+	}
+	return ans
+}
+
+// submitDue sends all the tasks with their next run time
+// reached and computes their next run.
+func (b *beat) submitDue(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, pt := range b.tasks {
+		if pt.nextRun.IsZero() || pt.nextRun.After(now) {
+			continue
+		}
+		task, err := b.master.SendTask(pt.conf.Fn, pt.conf.Args, &TaskOptions{Queue: pt.conf.Queue})
+		if err != nil {
+			log.Printf("ERROR: failed to submit periodic task %s: %s", pt.conf.Name, err)
+			pt.lastError = err.Error()
+
+		} else {
+			log.Printf("INFO: submitted periodic task %s as %s", pt.conf.Name, task.TaskID)
+			pt.lastTaskID = task.TaskID
+			pt.lastError = ""
+		}
+		pt.lastRun = now
+		// runs missed e.g. due to system suspend are not repeated
+		pt.computeNextRun(now)
+	}
+}
+
+// state returns runtime state of all the periodic tasks
+func (b *beat) state() map[string]periodicTaskState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ans := make(map[string]periodicTaskState)
+	for _, pt := range b.tasks {
+		ans[pt.key()] = periodicTaskState{
+			schedule:   pt.scheduleDesc(),
+			lastRun:    pt.lastRun,
+			nextRun:    pt.nextRun,
+			lastTaskID: pt.lastTaskID,
+			lastError:  pt.lastError,
+		}
+	}
+	return ans
+}
+
+// restore takes over the state of periodic tasks from
+// a previous beat (see state). A planned next run is kept
+// only in case the task's schedule has not changed.
+// It must be called before start.
+func (b *beat) restore(state map[string]periodicTaskState) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, pt := range b.tasks {
+		st, ok := state[pt.key()]
+		if !ok {
+			continue
+		}
+		pt.lastRun = st.lastRun
+		pt.lastTaskID = st.lastTaskID
+		pt.lastError = st.lastError
+		if st.schedule == pt.scheduleDesc() {
+			pt.nextRun = st.nextRun
+		}
+	}
+}
+
+// start computes first run times (unless restored)
+// and starts to submit tasks in a separate goroutine.
+func (b *beat) start() {
+	if len(b.tasks) == 0 {
+		return
+	}
+	b.mutex.Lock()
+	now := time.Now()
+	for _, pt := range b.tasks {
+		if pt.nextRun.IsZero() {
+			pt.computeNextRun(now)
+		}
+	}
+	done := make(chan bool)
+	b.done = done
+	b.mutex.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case now := <-ticker.C:
+				b.submitDue(now)
+			}
+		}
+	}()
+}
+
+// close stops submitting tasks and waits for a possible
+// submission in progress to finish so no task is submitted
+// once close returns. It can be called repeatedly. As the
+// submission uses Master.SendTask, close must not be called
+// with Master's lock held.
+func (b *beat) close() {
+	b.mutex.Lock()
+	if len(b.tasks) == 0 || b.closed {
+		b.mutex.Unlock()
+		return
+	}
+	b.stop <- true
+	b.closed = true
+	done := b.done
+	b.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func formatBeatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(beatTimeFormat)
+}
+
+func (b *beat) info() []PeriodicTaskInfo {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ans := make([]PeriodicTaskInfo, len(b.tasks))
+	for i, pt := range b.tasks {
+		ans[i] = PeriodicTaskInfo{
+			Name:       pt.conf.Name,
+			Fn:         pt.conf.Fn,
+			Schedule:   pt.scheduleDesc(),
+			LastRun:    formatBeatTime(pt.lastRun),
+			NextRun:    formatBeatTime(pt.nextRun),
+			LastTaskID: pt.lastTaskID,
+			LastError:  pt.lastError,
+		}
+	}
+	return ans
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeatStateSurvivesReload(t *testing.T) {
+	conf := []PeriodicTaskConf{
+		{Name: "daily", Fn: "foo", IntervalSeconds: 86400},
+		{Name: "hourly", Fn: "bar", IntervalSeconds: 3600},
+	}
+	lastRun := time.Now().Add(-10 * time.Hour)
+	b1 := newBeat(nil, conf)
+	b1.tasks[0].lastRun = lastRun
+	b1.tasks[0].lastTaskID = "t1"
+	b1.tasks[0].computeNextRun(lastRun)
+	b1.tasks[1].computeNextRun(lastRun)
+
+	// the second task changes its schedule
+	conf[1].IntervalSeconds = 60
+	b2 := newBeat(nil, conf)
+	b2.restore(b1.state())
+	b2.start()
+	defer b2.close()
+	info := b2.info()
+	assert.Equal(t, formatBeatTime(lastRun), info[0].LastRun)
+	assert.Equal(t, "t1", info[0].LastTaskID)
+	assert.Equal(t, formatBeatTime(lastRun.Add(24*time.Hour)), info[0].NextRun)
+	assert.True(t, b2.tasks[1].nextRun.After(time.Now()))
+	assert.True(t, b2.tasks[1].nextRun.Before(time.Now().Add(61*time.Second)))
+}
+
+func TestBeatCloseWaitsForSubmission(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		PeriodicTasks: []PeriodicTaskConf{
+			{Name: "often", Fn: "foo", IntervalSeconds: 1},
+		},
+	}, NewMemoryTaskStore())
+	m.beat.tasks[0].nextRun = time.Now()
+	m.beat.start()
+	time.Sleep(1100 * time.Millisecond)
+	m.beat.close()
+	numTasks := len(m.tasks.List())
+	assert.True(t, numTasks >= 1)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, numTasks, len(m.tasks.List()))
+	m.beat.close() // repeated close is a no-op
+}
+
+func TestDrainWithPeriodicTasks(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		PeriodicTasks: []PeriodicTaskConf{
+			{Name: "often", Fn: "foo", IntervalSeconds: 1},
+		},
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	m.beat.mutex.Lock()
+	m.beat.tasks[0].nextRun = time.Now()
+	m.beat.mutex.Unlock()
+	done := make(chan *Handover)
+	go func() {
+		time.Sleep(900 * time.Millisecond)
+		done <- m.Drain(time.Second)
+	}()
+	select {
+	case handover := <-done:
+		assert.Contains(t, handover.periodicTasks, m.beat.tasks[0].key())
+	case <-time.After(10 * time.Second):
+		t.Fatal("drain deadlocked")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// cronMaxLookAheadYears limits search for a next matching
+	// time (e.g. "0 0 30 2 *" never matches)
+	cronMaxLookAheadYears = 5
+)
+
+// cronField is a bit set of allowed values of a single
+// cron expression field
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// parseCronField parses a single field of a cron expression.
+// Supported forms are: "*", "n", "a-b", "*/s", "a-b/s"
+// and comma-separated lists of them.
+func parseCronField(expr string, min int, max int) (cronField, error) {
+	var ans cronField
+	for _, part := range strings.Split(expr, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s", part)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %s", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %s", part)
+				}
+
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("value %s out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			ans |= 1 << uint(v)
+		}
+	}
+	return ans, nil
+}
+
+// cronSchedule is a parsed cron expression
+// in the standard five-field format
+// (minute, hour, day of month, month, day of week)
+type cronSchedule struct {
+	minutes     cronField
+	hours       cronField
+	daysOfMonth cronField
+	months      cronField
+	daysOfWeek  cronField
+	anyDOM      bool
+	anyDOW      bool
+}
+
+// parseCron parses a cron expression (e.g. "*/15 2-4 * * 1-5").
+// Day of week is 0-7 with both 0 and 7 meaning Sunday.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, found %d", len(fields))
+	}
+	var err error
+	ans := &cronSchedule{
+		anyDOM: fields[2] == "*",
+		anyDOW: fields[4] == "*",
+	}
+	if ans.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if ans.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if ans.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if ans.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if ans.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if ans.daysOfWeek.has(7) {
+		ans.daysOfWeek |= 1
+	}
+	return ans, nil
+}
+
+// matchesDay applies the standard cron rule - if both day
+// of month and day of week are restricted, a day matching
+// any of them is accepted.
+func (cs *cronSchedule) matchesDay(t time.Time) bool {
+	dom := cs.daysOfMonth.has(t.Day())
+	dow := cs.daysOfWeek.has(int(t.Weekday()))
+	if cs.anyDOM || cs.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first matching time after t.
+// If there is no such time, zero time is returned.
+func (cs *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(cronMaxLookAheadYears, 0, 0)
+	for t.Before(limit) {
+		if !cs.months.has(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+
+		} else if !cs.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+
+		} else if !cs.hours.has(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+
+		} else if !cs.minutes.has(t.Minute()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mkTime(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCronEveryFifteenMinutes(t *testing.T) {
+	cs, err := parseCron("*/15 * * * *")
+	assert.Nil(t, err)
+	assert.Equal(t, mkTime("2018-05-01 10:15"), cs.next(mkTime("2018-05-01 10:00")))
+	assert.Equal(t, mkTime("2018-05-01 11:00"), cs.next(mkTime("2018-05-01 10:59")))
+}
+
+func TestCronDailyAtNight(t *testing.T) {
+	cs, err := parseCron("30 2 * * *")
+	assert.Nil(t, err)
+	assert.Equal(t, mkTime("2018-05-02 02:30"), cs.next(mkTime("2018-05-01 02:30")))
+	assert.Equal(t, mkTime("2019-01-01 02:30"), cs.next(mkTime("2018-12-31 03:00")))
+}
+
+func TestCronWeekdays(t *testing.T) {
+	cs, err := parseCron("0 8 * * 1-5")
+	assert.Nil(t, err)
+	// 2018-05-05 is Saturday
+	assert.Equal(t, mkTime("2018-05-07 08:00"), cs.next(mkTime("2018-05-05 09:00")))
+}
+
+func TestCronSundayAsSeven(t *testing.T) {
+	cs, err := parseCron("0 0 * * 7")
+	assert.Nil(t, err)
+	assert.Equal(t, mkTime("2018-05-06 00:00"), cs.next(mkTime("2018-05-05 09:00")))
+}
+
+func TestCronDayOfMonthOrDayOfWeek(t *testing.T) {
+	cs, err := parseCron("0 0 1 * 0")
+	assert.Nil(t, err)
+	assert.Equal(t, mkTime("2018-05-06 00:00"), cs.next(mkTime("2018-05-02 00:00")))
+	assert.Equal(t, mkTime("2018-06-01 00:00"), cs.next(mkTime("2018-05-27 00:00")))
+}
+
+func TestCronInvalid(t *testing.T) {
+	_, err := parseCron("* * * *")
+	assert.NotNil(t, err)
+	_, err = parseCron("61 * * * *")
+	assert.NotNil(t, err)
+	_, err = parseCron("*/0 * * * *")
+	assert.NotNil(t, err)
+	_, err = parseCron("5-1 * * * *")
+	assert.NotNil(t, err)
+}
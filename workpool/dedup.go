@@ -0,0 +1,61 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// argsDedupKey creates a deduplication key from a function
+// name and its arguments. As encoding/json sorts map keys,
+// arguments differing only in order of object keys produce
+// the same key.
+func argsDedupKey(fn string, args interface{}) (string, error) {
+	canonical, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	h.Write([]byte(fn))
+	h.Write([]byte{0})
+	h.Write(canonical)
+	return "args:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idempotencyDedupKey creates a deduplication key from
+// a client-provided idempotency key. The key is scoped
+// by function name.
+func idempotencyDedupKey(fn string, key string) string {
+	return "key:" + fn + ":" + key
+}
+
+// isReusable tests whether a task can be returned instead
+// of creating a new one with the same deduplication key.
+// Waiting, scheduled and running tasks are always reused,
+// successfully finished ones only within windowSeconds.
+func isReusable(task *Task, windowSeconds int) bool {
+	switch task.Status {
+	case taskStatusWaiting, taskStatusScheduled, taskStatusRunning:
+		return true
+	case taskStatusFinished:
+		return time.Now().Unix()-task.Updated <= int64(windowSeconds)
+	}
+	return false
+}
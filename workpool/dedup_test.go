@@ -0,0 +1,99 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgsDedupKeyIgnoresKeyOrder(t *testing.T) {
+	var args1, args2 interface{}
+	json.Unmarshal([]byte(`{"corpus": "syn2015", "q": ["aword,[word=\"x\"]"]}`), &args1)
+	json.Unmarshal([]byte(`{"q": ["aword,[word=\"x\"]"], "corpus": "syn2015"}`), &args2)
+	k1, err := argsDedupKey("worker.conc_register", args1)
+	assert.Nil(t, err)
+	k2, err := argsDedupKey("worker.conc_register", args2)
+	assert.Nil(t, err)
+	assert.Equal(t, k1, k2)
+	k3, err := argsDedupKey("worker.calculate_freqs", args1)
+	assert.Nil(t, err)
+	assert.NotEqual(t, k1, k3)
+}
+
+func TestIsReusable(t *testing.T) {
+	task := &Task{Status: taskStatusRunning}
+	assert.True(t, isReusable(task, 0))
+	task.Status = taskStatusFinished
+	task.Updated = time.Now().Unix() - 30
+	assert.True(t, isReusable(task, 60))
+	assert.False(t, isReusable(task, 10))
+	task.Status = taskStatusFailed
+	assert.False(t, isReusable(task, 60))
+	task.Status = taskStatusCancelled
+	assert.False(t, isReusable(task, 60))
+}
+
+func TestSendTaskIdempotencyKey(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, NewMemoryTaskStore())
+	task1, err := m.SendTask("foo", []byte(`{"a": 1}`), &TaskOptions{IdempotencyKey: "k1"})
+	assert.Nil(t, err)
+	task2, err := m.SendTask("foo", []byte(`{"a": 2}`), &TaskOptions{IdempotencyKey: "k1"})
+	assert.Nil(t, err)
+	assert.Equal(t, task1.TaskID, task2.TaskID)
+	task3, err := m.SendTask("foo", []byte(`{"a": 1}`), &TaskOptions{IdempotencyKey: "k2"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, task1.TaskID, task3.TaskID)
+	task4, err := m.SendTask("bar", []byte(`{"a": 1}`), &TaskOptions{IdempotencyKey: "k1"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, task1.TaskID, task4.TaskID)
+	assert.Equal(t, 3, m.getQueue(defaultQueueName).size())
+}
+
+func TestSendTaskDeduplicationWindow(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:                   1,
+		Program:                    "sh",
+		ProgramArgs:                []string{"-c", echoWorker},
+		ExecMaxSeconds:             10,
+		DeduplicateByArgs:          true,
+		DeduplicationWindowSeconds: 1,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task1, err := m.SendTask("foo", []byte(`{"a": 1, "b": 2}`), &TaskOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusFinished, waitForTask(t, m, task1.TaskID).Status)
+
+	task2, err := m.SendTask("foo", []byte(`{"b": 2, "a": 1}`), &TaskOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, task1.TaskID, task2.TaskID)
+	task3, err := m.SendTask("foo", []byte(`{"a": 2}`), &TaskOptions{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, task1.TaskID, task3.TaskID)
+
+	time.Sleep(2100 * time.Millisecond)
+	task4, err := m.SendTask("foo", []byte(`{"a": 1, "b": 2}`), &TaskOptions{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, task1.TaskID, task4.TaskID)
+	assert.Equal(t, taskStatusFinished, waitForTask(t, m, task4.TaskID).Status)
+}
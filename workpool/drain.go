@@ -0,0 +1,155 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	drainPollInterval = 200 * time.Millisecond
+
+	defaultDrainGraceSeconds = 30
+
+	// DrainRetryAfterSeconds is a suggested delay before
+	// a client repeats a submission rejected during drain
+	DrainRetryAfterSeconds = 5
+)
+
+// ErrDraining is returned by SendTask and SendWorkflow
+// once Master has started to drain
+var ErrDraining = errors.New("task queue is being drained")
+
+// Handover contains everything a drained Master passes
+// to its successor
+type Handover struct {
+
+	// Tasks contains waiting and scheduled tasks
+	Tasks []*Task
+
+	workflows map[string]*Workflow
+
+	periodicTasks map[string]periodicTaskState
+}
+
+// DrainGrace returns the configured time
+// running tasks have to finish during drain
+func (conf *MasterConf) DrainGrace() time.Duration {
+	if conf.DrainGraceSeconds > 0 {
+		return time.Duration(conf.DrainGraceSeconds) * time.Second
+	}
+	return defaultDrainGraceSeconds * time.Second
+}
+
+func (m *Master) numRunningTasks() int {
+	ans := 0
+	for _, task := range m.workers {
+		if task != nil {
+			ans++
+		}
+	}
+	return ans
+}
+
+// Drain stops accepting new tasks and stops executing
+// waiting ones. Running tasks are given 'grace' time to
+// finish - the ones still running after that are failed as
+// interrupted (and possibly scheduled for retry according to
+// their retry policy). Pending tasks are then removed from
+// queues and returned along with other state needed by the next
+// Master (see Adopt). Workers are not stopped - Stop must be
+// called afterwards.
+func (m *Master) Drain(grace time.Duration) *Handover {
+	m.mutex.Lock()
+	m.draining = true
+	m.mutex.Unlock()
+	m.beat.close()
+	log.Printf("INFO: draining task queue (grace period %v)", grace)
+
+	deadline := time.Now().Add(grace)
+	for {
+		m.mutex.Lock()
+		numRunning := m.numRunningTasks()
+		m.mutex.Unlock()
+		if numRunning == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("WARNING: %d task(s) still running after the grace period", numRunning)
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	// beat calls SendTask with its own lock held so it
+	// must not be accessed with Master's lock held
+	periodicTasks := m.beat.state()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for worker, task := range m.workers {
+		if task != nil {
+			m.releaseWorker(worker)
+			m.failTask(task, &TaskError{
+				Kind:      errorKindInterrupted,
+				Message:   "Task interrupted by konserver reload or shutdown",
+				WorkerPID: worker.GetPID(),
+			})
+		}
+	}
+	m.advanceWorkflows()
+	ans := &Handover{
+		Tasks:         make([]*Task, 0, m.numWaitingTasks()+m.scheduler.size()),
+		workflows:     m.workflows,
+		periodicTasks: periodicTasks,
+	}
+	for _, q := range m.queues {
+		for task := q.pop(); task != nil; task = q.pop() {
+			ans.Tasks = append(ans.Tasks, task)
+		}
+	}
+	ans.Tasks = append(ans.Tasks, m.scheduler.popDue(math.MaxInt64)...)
+	m.workflows = make(map[string]*Workflow)
+	log.Printf("INFO: task queue drained, %d pending task(s) to hand over", len(ans.Tasks))
+	return ans
+}
+
+// Adopt takes over pending tasks, workflows and periodic
+// task schedules of a drained Master. It must be called
+// before Start.
+func (m *Master) Adopt(handover *Handover) {
+	if handover == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, task := range handover.Tasks {
+		// tasks are enqueued by Start (see restoreTasks)
+		err := m.tasks.Put(task)
+		if err != nil {
+			log.Printf("ERROR: failed to adopt task %s: %s", task.TaskID, err)
+		}
+	}
+	for workflowID, wf := range handover.workflows {
+		m.workflows[workflowID] = wf
+	}
+	m.beat.restore(handover.periodicTasks)
+	log.Printf("INFO: adopted %d pending task(s) and %d workflow(s)",
+		len(handover.Tasks), len(handover.workflows))
+}
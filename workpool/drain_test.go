@@ -0,0 +1,73 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainAndAdopt(t *testing.T) {
+	store := NewMemoryTaskStore()
+	conf := &MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `while read line; do sleep 5; echo '{"status": 0}'; done`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}
+	m1 := NewMaster(conf, store)
+	m1.Start()
+	running, err := m1.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(300 * time.Millisecond)
+	waiting, err := m1.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	scheduled, err := m1.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusRunning, m1.GetTask(running.TaskID).Status)
+
+	handover := m1.Drain(500 * time.Millisecond)
+	_, err = m1.SendTask("foo", nil, &TaskOptions{})
+	assert.Equal(t, ErrDraining, err)
+	m1.Stop()
+	assert.Equal(t, 2, len(handover.Tasks))
+	task := m1.GetTask(running.TaskID)
+	assert.Equal(t, taskStatusFailed, task.Status)
+	assert.Equal(t, errorKindInterrupted, task.ErrorDetail.Kind)
+
+	// the successor cannot start its worker so the
+	// adopted waiting task stays in its queue
+	conf2 := *conf
+	conf2.Program = "/nonexistent/prog"
+	m2 := NewMaster(&conf2, store)
+	m2.Adopt(handover)
+	m2.Start()
+	defer m2.Stop()
+	assert.Equal(t, taskStatusWaiting, m2.GetTask(waiting.TaskID).Status)
+	assert.Equal(t, taskStatusScheduled, m2.GetTask(scheduled.TaskID).Status)
+	assert.Equal(t, taskStatusFailed, m2.GetTask(running.TaskID).Status)
+	m2.mutex.Lock()
+	defer m2.mutex.Unlock()
+	q := m2.getQueue(defaultQueueName)
+	assert.Equal(t, 1, q.size())
+	assert.Equal(t, waiting.TaskID, q.pop().TaskID)
+	assert.Equal(t, 1, m2.scheduler.size())
+}
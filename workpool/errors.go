@@ -0,0 +1,95 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	// errorKindCrash means the worker process died
+	// while executing the task
+	errorKindCrash = "crash"
+
+	// errorKindTimeout means the task reached
+	// its execution time limit
+	errorKindTimeout = "timeout"
+
+	// errorKindApplication means the worker finished
+	// the task and reported an error
+	errorKindApplication = "application"
+
+	// errorKindProtocol means the worker's response
+	// could not be read or decoded
+	errorKindProtocol = "protocol"
+
+	// errorKindInterrupted means the task was running
+	// when konserver has been stopped
+	errorKindInterrupted = "interrupted"
+
+	// errorKindInternal means konserver failed to process
+	// a (otherwise successful) worker's response
+	errorKindInternal = "internal"
+)
+
+// TaskError describes why a task failed
+type TaskError struct {
+
+	// Kind classifies the error. It is one of "crash",
+	// "timeout", "application", "protocol", "interrupted"
+	// and "internal" unless a worker reports its own
+	// kind along with the error.
+	Kind string `json:"kind"`
+
+	Message string `json:"message"`
+
+	// Traceback is reported by a worker in case of
+	// an application error. In case of a crash, it contains
+	// the worker's stderr output written during the task.
+	Traceback []string `json:"traceback,omitempty"`
+
+	// WorkerPID identifies the worker process
+	// which executed the failed attempt
+	WorkerPID int `json:"workerPID,omitempty"`
+
+	// ExitCode is set in case the worker process
+	// exited while executing the task
+	ExitCode *int `json:"exitCode,omitempty"`
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+// processExitCode extracts an exit code from an error returned
+// by exec.Cmd.Wait. In case the process has been killed by
+// a signal, -1 is returned. In case the error does not
+// describe a process exit, nil is returned.
+func processExitCode(err error) *int {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return nil
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return nil
+	}
+	code := status.ExitStatus()
+	return &code
+}
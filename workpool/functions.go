@@ -0,0 +1,141 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// FunctionInfo describes a task function announced
+// by workers in their "hello" handshake message
+type FunctionInfo struct {
+	Name string `json:"name"`
+
+	// Pool is a name of a pool whose workers
+	// announced the function (set by Master)
+	Pool string `json:"pool"`
+
+	// ArgsSchema is an optional JSON schema
+	// of the function's arguments
+	ArgsSchema json.RawMessage `json:"argsSchema,omitempty"`
+
+	schema *jsonSchema
+}
+
+// UnknownFunctionError is returned in case a task
+// function is not supported by workers of the pool
+// the task is routed to
+type UnknownFunctionError struct {
+	Fn   string
+	Pool string
+}
+
+func (e *UnknownFunctionError) Error() string {
+	return fmt.Sprintf("unknown task function %s (pool %s)", e.Fn, e.Pool)
+}
+
+// registerFunctions replaces functions of a worker's pool
+// with the ones announced by the worker. As all the workers
+// of a pool run the same program, the last announcement wins.
+func (m *Master) registerFunctions(worker *Worker, functions []FunctionInfo) {
+	pool := worker.pool
+	registry := make(map[string]FunctionInfo, len(functions))
+	for _, fn := range functions {
+		if fn.Name == "" {
+			log.Printf("WARNING: worker %v announced a function without name", worker)
+			continue
+		}
+		fn.Pool = pool.conf.Name
+		if len(fn.ArgsSchema) > 0 {
+			schema, err := parseSchema(fn.ArgsSchema)
+			if err != nil {
+				log.Printf("WARNING: worker %v announced invalid args schema of %s: %s", worker, fn.Name, err)
+
+			} else {
+				fn.schema = schema
+			}
+		}
+		registry[fn.Name] = fn
+	}
+	if pool.functions == nil || len(pool.functions) != len(registry) {
+		log.Printf("INFO: pool %s announced %d function(s)", pool.conf.Name, len(registry))
+	}
+	pool.functions = registry
+}
+
+// checkFunction tests whether a task function is supported
+// by the pool the function is routed to. Pools which have
+// not announced their functions yet (e.g. workers not
+// supporting the handshake) accept any function.
+func (m *Master) checkFunction(fn string) error {
+	pool := m.routePool(fn)
+	if pool.functions == nil {
+		return nil
+	}
+	if _, ok := pool.functions[fn]; !ok {
+		return &UnknownFunctionError{Fn: fn, Pool: pool.conf.Name}
+	}
+	return nil
+}
+
+// argsSchema returns a schema of a task function's arguments.
+// A configured schema takes precedence over the one announced
+// by workers. In case there is no schema, nil is returned.
+func (m *Master) argsSchema(fn string) *jsonSchema {
+	if schema, ok := m.argsSchemas[fn]; ok {
+		return schema
+	}
+	if info, ok := m.routePool(fn).functions[fn]; ok {
+		return info.schema
+	}
+	return nil
+}
+
+// validateArgs validates task arguments against a schema
+// of the task function (if any)
+func (m *Master) validateArgs(fn string, args interface{}) error {
+	schema := m.argsSchema(fn)
+	if schema == nil {
+		return nil
+	}
+	if violations := schema.validate(args); len(violations) > 0 {
+		return &ValidationError{Fn: fn, Violations: violations}
+	}
+	return nil
+}
+
+// Functions returns task functions announced by workers
+// of all the pools (sorted by pool and function name)
+func (m *Master) Functions() []FunctionInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ans := make([]FunctionInfo, 0, 20)
+	for _, pool := range m.pools {
+		fns := make([]FunctionInfo, 0, len(pool.functions))
+		for _, fn := range pool.functions {
+			fns = append(fns, fn)
+		}
+		sort.Slice(fns, func(i, j int) bool {
+			return fns[i].Name < fns[j].Name
+		})
+		ans = append(ans, fns...)
+	}
+	return ans
+}
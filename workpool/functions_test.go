@@ -0,0 +1,71 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFunction(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		Pools: []PoolConf{
+			{Name: "a", PoolSize: 1},
+			{Name: "b", PoolSize: 1},
+		},
+		PoolRoutes: []PoolRoute{{FnPrefix: "b.", Pool: "b"}},
+	}, NewMemoryTaskStore())
+	assert.Nil(t, m.checkFunction("b.foo"))
+	m.registerFunctions(&Worker{pool: m.getPool("b")}, []FunctionInfo{{Name: "b.foo"}})
+	assert.Nil(t, m.checkFunction("b.foo"))
+	assert.Equal(t, &UnknownFunctionError{Fn: "b.bar", Pool: "b"}, m.checkFunction("b.bar"))
+	// pool "a" has not announced anything yet
+	assert.Nil(t, m.checkFunction("a.bar"))
+	assert.Equal(t, []FunctionInfo{{Name: "b.foo", Pool: "b"}}, m.Functions())
+}
+
+// helloWorker announces function "foo" once started
+const helloWorker = `echo '{"control": "hello", "functions": [{"name": "foo"}]}'
+while read line; do
+	echo '{"status": 0, "result": "done"}'
+done`
+
+func TestWorkerHelloRegistersFunctions(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", helloWorker},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Functions()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, []FunctionInfo{{Name: "foo", Pool: defaultPoolName}}, m.Functions())
+
+	_, err := m.SendTask("bar", []byte(`{}`), &TaskOptions{})
+	assert.Equal(t, &UnknownFunctionError{Fn: "bar", Pool: defaultPoolName}, err)
+	task, err := m.SendTask("foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "done", waitForTask(t, m, task.TaskID).Result)
+}
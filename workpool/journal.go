@@ -0,0 +1,296 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultJournalMaxBytes = 10 * 1024 * 1024
+	defaultJournalMaxFiles = 5
+
+	// journalMaxLineSize is max. size of a journal entry
+	// accepted during replay
+	journalMaxLineSize = 16 * 1024 * 1024
+)
+
+// task lifecycle events written to the journal
+const (
+	journalSubmitted = "submitted"
+	journalDequeued  = "dequeued"
+	journalStarted   = "started"
+	journalFinished  = "finished"
+	journalRetried   = "retried"
+	journalFailed    = "failed"
+	journalCancelled = "cancelled"
+	journalExpired   = "expired"
+
+	// journalCheckpoint is written for each pending task once the
+	// journal is rotated so replay does not depend on old files
+	journalCheckpoint = "checkpoint"
+)
+
+// JournalEntry is a single line of the task journal. Each entry
+// contains a snapshot of the task (without its result) so the
+// journal can be replayed.
+type JournalEntry struct {
+	Time      string     `json:"time"`
+	Event     string     `json:"event"`
+	TaskID    string     `json:"taskID"`
+	Fn        string     `json:"fn"`
+	Queue     string     `json:"queue,omitempty"`
+	WorkerPID int        `json:"workerPID,omitempty"`
+	Error     *TaskError `json:"error,omitempty"`
+	Task      *Task      `json:"task"`
+}
+
+// journal is an append-only file with task lifecycle events
+// (one JSON-encoded JournalEntry per line). Once the file reaches
+// its max. size, it is rotated (path -> path.1 -> path.2 ...).
+// The type is not thread-safe - Master accesses it only with
+// its mutex locked.
+type journal struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openJournal opens (or creates) a journal file for appending
+func openJournal(conf *MasterConf) (*journal, error) {
+	ans := &journal{
+		path:     conf.JournalPath,
+		maxBytes: conf.JournalMaxBytes,
+		maxFiles: conf.JournalMaxFiles,
+	}
+	if ans.maxBytes <= 0 {
+		ans.maxBytes = defaultJournalMaxBytes
+	}
+	if ans.maxFiles <= 0 {
+		ans.maxFiles = defaultJournalMaxFiles
+	}
+	if err := ans.open(); err != nil {
+		return nil, err
+	}
+	return ans, nil
+}
+
+func (j *journal) open() error {
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+// rotatedPath returns a path of the i-th rotated file
+// (0 = the current one)
+func (j *journal) rotatedPath(i int) string {
+	if i == 0 {
+		return j.path
+	}
+	return fmt.Sprintf("%s.%d", j.path, i)
+}
+
+func (j *journal) needsRotation() bool {
+	return j.size >= j.maxBytes
+}
+
+// rotate closes the current file, shifts rotated
+// files and opens a new one. The oldest file
+// is removed.
+func (j *journal) rotate() error {
+	j.file.Close()
+	os.Remove(j.rotatedPath(j.maxFiles))
+	for i := j.maxFiles - 1; i >= 0; i-- {
+		if _, err := os.Stat(j.rotatedPath(i)); err == nil {
+			if err := os.Rename(j.rotatedPath(i), j.rotatedPath(i+1)); err != nil {
+				log.Printf("ERROR: failed to rotate journal file %s: %s", j.rotatedPath(i), err)
+			}
+		}
+	}
+	return j.open()
+}
+
+func (j *journal) write(entry *JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	n, err := j.file.Write(data)
+	j.size += int64(n)
+	return err
+}
+
+func (j *journal) close() {
+	if err := j.file.Close(); err != nil {
+		log.Print("ERROR: failed to close journal: ", err)
+	}
+}
+
+// replay reads all the journal files (oldest first) and returns
+// the last known state of tasks which are not done yet.
+// Incomplete or corrupted lines (e.g. the last one written
+// during a crash) are skipped.
+func (j *journal) replay() ([]*Task, error) {
+	states := make(map[string]*Task)
+	order := make([]string, 0, 100)
+	for i := j.maxFiles; i >= 0; i-- {
+		file, err := os.Open(j.rotatedPath(i))
+		if os.IsNotExist(err) {
+			continue
+
+		} else if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), journalMaxLineSize)
+		lineNum := 0
+		for scanner.Scan() {
+			lineNum++
+			var entry JournalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Task == nil {
+				log.Printf("WARNING: skipping invalid journal entry %s:%d", j.rotatedPath(i), lineNum)
+				continue
+			}
+			if _, ok := states[entry.TaskID]; !ok {
+				order = append(order, entry.TaskID)
+			}
+			if entry.Event == journalExpired {
+				states[entry.TaskID] = nil
+
+			} else {
+				states[entry.TaskID] = entry.Task
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ans := make([]*Task, 0, len(order))
+	for _, taskID := range order {
+		if task := states[taskID]; task != nil && !task.IsDone() {
+			ans = append(ans, task)
+		}
+	}
+	return ans, nil
+}
+
+// ---------------------------------------------------------------
+
+// journalSnapshot returns a copy of a task suitable
+// for the journal (i.e. without its potentially large
+// result and stderr output)
+func journalSnapshot(task *Task) *Task {
+	ans := task.clone()
+	ans.Result = nil
+	ans.Stderr = nil
+	ans.Progress = nil
+	return ans
+}
+
+// journalTask writes a task lifecycle event to the journal
+// (if configured). Once the journal is rotated, all the pending
+// tasks are written to the new file as checkpoints.
+func (m *Master) journalTask(event string, task *Task, workerPID int) {
+	if m.journal == nil {
+		return
+	}
+	err := m.journal.write(&JournalEntry{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Event:     event,
+		TaskID:    task.TaskID,
+		Fn:        task.Fn,
+		Queue:     task.Queue,
+		WorkerPID: workerPID,
+		Error:     task.ErrorDetail,
+		Task:      journalSnapshot(task),
+	})
+	if err != nil {
+		log.Printf("ERROR: failed to write journal entry (task %s, %s): %s", task.TaskID, event, err)
+	}
+	if m.journal.needsRotation() {
+		if err := m.journal.rotate(); err != nil {
+			log.Print("ERROR: failed to rotate journal, journal disabled: ", err)
+			m.journal = nil
+			return
+		}
+		for _, t := range m.tasks.List() {
+			if !t.IsDone() {
+				m.journal.write(&JournalEntry{
+					Time:   time.Now().Format(time.RFC3339Nano),
+					Event:  journalCheckpoint,
+					TaskID: t.TaskID,
+					Fn:     t.Fn,
+					Queue:  t.Queue,
+					Task:   journalSnapshot(t),
+				})
+			}
+		}
+	}
+}
+
+// openJournal opens the journal (if configured) and puts tasks
+// found there which are not present in the task store yet into
+// the store. The tasks are then restored by restoreTasks
+// (i.e. running ones are treated as interrupted).
+func (m *Master) openJournal() {
+	if m.conf.JournalPath == "" {
+		return
+	}
+	jrnl, err := openJournal(m.conf)
+	if err != nil {
+		log.Print("ERROR: failed to open task journal, journal disabled: ", err)
+		return
+	}
+	pending, err := jrnl.replay()
+	if err != nil {
+		log.Print("ERROR: failed to replay task journal: ", err)
+	}
+	numReplayed := 0
+	for _, task := range pending {
+		if m.tasks.Get(task.TaskID) != nil {
+			continue
+		}
+		if err := m.tasks.Put(task); err != nil {
+			log.Printf("ERROR: failed to restore task %s from journal: %s", task.TaskID, err)
+			continue
+		}
+		numReplayed++
+	}
+	if numReplayed > 0 {
+		log.Printf("INFO: replayed %d pending task(s) from journal", numReplayed)
+	}
+	m.journal = jrnl
+}
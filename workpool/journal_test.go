@@ -0,0 +1,77 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-journal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	jrnl, err := openJournal(&MasterConf{JournalPath: filepath.Join(dir, "journal.jsonl")})
+	assert.Nil(t, err)
+	write := func(event string, task Task) {
+		assert.Nil(t, jrnl.write(&JournalEntry{Event: event, TaskID: task.TaskID, Task: &task}))
+	}
+	write(journalSubmitted, Task{TaskID: "a", Status: taskStatusWaiting})
+	write(journalSubmitted, Task{TaskID: "b", Status: taskStatusWaiting})
+	write(journalStarted, Task{TaskID: "a", Status: taskStatusRunning})
+	write(journalFinished, Task{TaskID: "b", Status: taskStatusFinished})
+	write(journalSubmitted, Task{TaskID: "c", Status: taskStatusScheduled})
+	write(journalExpired, Task{TaskID: "c", Status: taskStatusScheduled})
+	jrnl.file.WriteString("{\"event\": \"subm") // e.g. a crash during write
+	jrnl.close()
+
+	pending, err := jrnl.replay()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "a", pending[0].TaskID)
+	assert.Equal(t, taskStatusRunning, pending[0].Status)
+}
+
+func TestJournalRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-journal")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+	m := NewMaster(&MasterConf{JournalPath: path, JournalMaxBytes: 1, JournalMaxFiles: 2}, NewMemoryTaskStore())
+	m.openJournal()
+	for _, taskID := range []string{"a", "b", "c", "d"} {
+		task := &Task{TaskID: taskID, Status: taskStatusWaiting}
+		m.tasks.Put(task)
+		m.journalTask(journalSubmitted, task, 0)
+	}
+	m.journal.close()
+	_, err = os.Stat(path + ".2")
+	assert.Nil(t, err)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// tasks submitted before the oldest kept file are
+	// restored thanks to checkpoints
+	m2 := NewMaster(&MasterConf{JournalPath: path, JournalMaxFiles: 2}, NewMemoryTaskStore())
+	m2.openJournal()
+	m2.journal.close()
+	assert.Equal(t, 4, len(m2.tasks.List()))
+}
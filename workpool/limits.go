@@ -0,0 +1,51 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+// ExecLimit specifies execution time limits of a task.
+// Zero values mean "use the global value".
+type ExecLimit struct {
+
+	// ExecMaxSeconds is a hard limit - once reached,
+	// the worker is killed.
+	ExecMaxSeconds int `json:"execMaxSeconds"`
+
+	// SoftExecMaxSeconds is a soft limit - once reached,
+	// the worker receives SIGUSR1 so it can clean up
+	// and finish the task (similar to Celery's soft
+	// time limit).
+	SoftExecMaxSeconds int `json:"softExecMaxSeconds"`
+}
+
+// execLimit returns execution limits for a specified
+// task function with pool values used where no
+// function-specific value is configured.
+func (conf *MasterConf) execLimit(fn string, pool *PoolConf) ExecLimit {
+	ans := ExecLimit{
+		ExecMaxSeconds:     pool.ExecMaxSeconds,
+		SoftExecMaxSeconds: pool.SoftExecMaxSeconds,
+	}
+	if fnLimit, ok := conf.ExecLimits[fn]; ok {
+		if fnLimit.ExecMaxSeconds > 0 {
+			ans.ExecMaxSeconds = fnLimit.ExecMaxSeconds
+		}
+		if fnLimit.SoftExecMaxSeconds > 0 {
+			ans.SoftExecMaxSeconds = fnLimit.SoftExecMaxSeconds
+		}
+	}
+	return ans
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecLimit(t *testing.T) {
+	conf := &MasterConf{
+		ExecMaxSeconds:     10,
+		SoftExecMaxSeconds: 5,
+		Pools: []PoolConf{
+			{Name: "a", PoolSize: 1, ExecMaxSeconds: 20},
+			{Name: "b", PoolSize: 1},
+		},
+		ExecLimits: map[string]ExecLimit{
+			"f1": {ExecMaxSeconds: 30},
+			"f2": {SoftExecMaxSeconds: 2},
+		},
+	}
+	pools := make(map[string]*PoolConf)
+	poolConfs := conf.poolConfs()
+	for i := range poolConfs {
+		pools[poolConfs[i].Name] = &poolConfs[i]
+	}
+	testCases := []struct {
+		fn    string
+		pool  string
+		limit ExecLimit
+	}{
+		{"f1", "a", ExecLimit{ExecMaxSeconds: 30, SoftExecMaxSeconds: 5}},
+		{"f1", "b", ExecLimit{ExecMaxSeconds: 30, SoftExecMaxSeconds: 5}},
+		{"f2", "a", ExecLimit{ExecMaxSeconds: 20, SoftExecMaxSeconds: 2}},
+		{"f3", "a", ExecLimit{ExecMaxSeconds: 20, SoftExecMaxSeconds: 5}},
+		{"f3", "b", ExecLimit{ExecMaxSeconds: 10, SoftExecMaxSeconds: 5}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.limit, conf.execLimit(tc.fn, pools[tc.pool]), "%s in %s", tc.fn, tc.pool)
+	}
+}
+
+// softLimitWorker writes a line to a file each time it receives
+// SIGUSR1. Each task takes 3.5 s and ends with an error.
+const softLimitWorker = `trap 'echo usr1 >> %s' USR1
+while read line; do
+	i=0; while [ $i -lt 35 ]; do sleep 0.1; i=$((i+1)); done
+	echo '{"status": 0, "error": "failed"}'
+done`
+
+func TestSoftLimitSignalledOncePerAttempt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-limits")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	signals := filepath.Join(dir, "signals")
+	m := NewMaster(&MasterConf{
+		PoolSize:           1,
+		Program:            "sh",
+		ProgramArgs:        []string{"-c", fmt.Sprintf(softLimitWorker, signals)},
+		ExecMaxSeconds:     10,
+		SoftExecMaxSeconds: 1,
+		RetryPolicies: map[string]RetryPolicy{
+			"foo": {MaxAttempts: 2, RetryOn: []string{errorKindApplication}},
+		},
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	deadline := time.Now().Add(12 * time.Second)
+	for !m.GetTask(task.TaskID).IsDone() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	task = m.GetTask(task.TaskID)
+	assert.Equal(t, taskStatusFailed, task.Status)
+	assert.Equal(t, 2, task.Attempt)
+	data, err := ioutil.ReadFile(signals)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "usr1"))
+}
@@ -17,9 +17,12 @@
 package workpool
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +35,29 @@ type MasterConf struct {
 	// PoolSize specifies number of workers
 	PoolSize int `json:"poolSize"`
 
+	// MinPoolSize and MaxPoolSize (if MaxPoolSize is non-zero)
+	// replace the fixed PoolSize. Master then spawns new workers
+	// (up to MaxPoolSize) if there are waiting tasks and no free
+	// worker and it stops workers idle for longer than
+	// WorkerIdleTimeoutSeconds (down to MinPoolSize).
+	MinPoolSize int `json:"minPoolSize"`
+
+	MaxPoolSize int `json:"maxPoolSize"`
+
+	// WorkerIdleTimeoutSeconds specifies how long a worker above
+	// MinPoolSize can stay idle before it is stopped. Only elastic
+	// pools are affected. Zero means the default 600 seconds.
+	WorkerIdleTimeoutSeconds int `json:"workerIdleTimeoutSeconds"`
+
+	// MaxTasksPerWorker specifies how many tasks a worker process
+	// can execute before it is replaced by a fresh one (0 = no limit)
+	MaxTasksPerWorker int `json:"maxTasksPerWorker"`
+
+	// MaxWorkerRSSBytes specifies max. resident memory of a worker
+	// process. Once exceeded (measured after a task is finished)
+	// the process is replaced by a fresh one (0 = no limit).
+	MaxWorkerRSSBytes int64 `json:"maxWorkerRSSBytes"`
+
 	// Program specifies program name (basically the first
 	// element of a command we want to use as a worker)
 	Program string `json:"program"`
@@ -45,14 +71,123 @@ type MasterConf struct {
 	// task is actually started - not equeued).
 	ExecMaxSeconds int `json:"execMaxSeconds"`
 
+	// SoftExecMaxSeconds specifies a time after which
+	// a worker is notified (via SIGUSR1) that it should
+	// finish its task. Zero means no soft limit.
+	SoftExecMaxSeconds int `json:"softExecMaxSeconds"`
+
+	// ExecLimits overrides the two limits above
+	// for specific task functions.
+	ExecLimits map[string]ExecLimit `json:"execLimits"`
+
 	TaskResultPersistMaxSeconds int `json:"taskResultPersistMaxSeconds"`
 
 	MaxResponsePipeBufferSize int `json:"maxResponsePipeBufferSize"`
+
+	// DrainGraceSeconds specifies how long running tasks can
+	// take to finish when konserver is reloaded or stopped
+	DrainGraceSeconds int `json:"drainGraceSeconds"`
+
+	// HeartbeatIntervalSeconds specifies how often idle workers
+	// are pinged (0 = heartbeat disabled). A worker must answer
+	// a {"control": "ping"} line with {"control": "pong"}. Busy
+	// workers are not pinged as they are watched by exec. limits.
+	HeartbeatIntervalSeconds int `json:"heartbeatIntervalSeconds"`
+
+	// HeartbeatMaxMissed specifies how many consecutive pings
+	// a worker can miss before it is restarted (default is 3)
+	HeartbeatMaxMissed int `json:"heartbeatMaxMissed"`
+
+	// StderrBufferLines specifies how many lines of each worker's
+	// stderr output are kept in memory (default is 100). Lines written
+	// during a failed task are attached to the task.
+	StderrBufferLines int `json:"stderrBufferLines"`
+
+	// TaskStoreDir specifies a directory where tasks
+	// are stored so they survive konserver reload/restart.
+	// If empty, tasks are kept in memory only.
+	TaskStoreDir string `json:"taskStoreDir"`
+
+	// Queues specifies named task queues. A task is routed
+	// to the first queue with a matching function name prefix
+	// unless a client specifies the queue explicitly. Tasks
+	// matching no queue go to the "default" one.
+	Queues []QueueConf `json:"queues"`
+
+	// ResultFilesDir specifies a directory where results
+	// passed by workers as files are stored. The files are
+	// removed along with expired tasks.
+	ResultFilesDir string `json:"resultFilesDir"`
+
+	// MaxQueueLength specifies max. number of waiting tasks
+	// in each queue (0 = no limit). Submissions exceeding the limit
+	// are rejected with QueueFullError. The limit can be overridden
+	// for specific queues via QueueConf.MaxLength.
+	MaxQueueLength int `json:"maxQueueLength"`
+
+	// RetryPolicies specifies (per task function) how
+	// failed tasks are retried. Tasks of functions without
+	// a policy are executed just once.
+	RetryPolicies map[string]RetryPolicy `json:"retryPolicies"`
+
+	// DeduplicateByArgs enables automatic deduplication of tasks
+	// with the same function and arguments (see also
+	// DeduplicationWindowSeconds).
+	DeduplicateByArgs bool `json:"deduplicateByArgs"`
+
+	// DeduplicationWindowSeconds specifies how long a successfully
+	// finished task is returned for a repeated submission (either
+	// with the same idempotency key or - if DeduplicateByArgs is
+	// enabled - with the same arguments). Waiting and running tasks
+	// are returned regardless of the window.
+	DeduplicationWindowSeconds int `json:"deduplicationWindowSeconds"`
+
+	// PeriodicTasks specifies tasks submitted repeatedly
+	// by the built-in scheduler (a replacement for Celery beat)
+	PeriodicTasks []PeriodicTaskConf `json:"periodicTasks"`
+
+	// Pools specifies named worker pools running different
+	// programs. If empty, a single "default" pool is created
+	// from Program, ProgramArgs and the pool size values above.
+	Pools []PoolConf `json:"pools"`
+
+	// PoolRoutes maps task functions (by their names or name
+	// prefixes) to pools. The first matching route wins. Tasks
+	// matching no route go to the "default" pool (or to the first
+	// configured pool in case there is no "default" one).
+	PoolRoutes []PoolRoute `json:"poolRoutes"`
+
+	// ArgsSchemas specifies (per task function) JSON schemas
+	// the task arguments must conform to. A schema configured
+	// here takes precedence over a schema announced by workers.
+	ArgsSchemas map[string]json.RawMessage `json:"argsSchemas"`
+
+	// JournalPath specifies a file where task lifecycle events
+	// are written (as JSON lines). The journal is replayed
+	// on startup so pending tasks survive even a crash.
+	// If empty, no journal is written.
+	JournalPath string `json:"journalPath"`
+
+	// JournalMaxBytes specifies a size of the journal file
+	// which triggers its rotation (default is 10 MiB)
+	JournalMaxBytes int64 `json:"journalMaxBytes"`
+
+	// JournalMaxFiles specifies how many rotated journal
+	// files are kept (default is 5)
+	JournalMaxFiles int `json:"journalMaxFiles"`
 }
 
 type MasterInfo struct {
-	PoolSize    int
-	WorkersInfo []WorkerInfo
+	PoolSize      int
+	MinPoolSize   int
+	MaxPoolSize   int
+	Recycled      int
+	WorkersInfo   []WorkerInfo
+	Pools         []PoolInfo
+	Queues        []QueueInfo
+	Scheduled     int
+	ErrorCounts   map[string]int
+	PeriodicTasks []PeriodicTaskInfo
 }
 
 // Master handles distribution of tasks to workers
@@ -60,138 +195,899 @@ type MasterInfo struct {
 type Master struct {
 	conf        *MasterConf
 	workers     map[*Worker]*Task
-	tasks       map[string]*Task
-	queue       chan *Task
+	tasks       TaskStore
+	queues      []*taskQueue // sorted by priority (highest first)
+	scheduler   *taskScheduler
 	queueEvent  chan bool // true = new item, false = removed item
 	workerEvent chan *WorkerStatus
 	mutex       *sync.Mutex
+	stop        chan bool
+	stopped     chan bool     // closed once the event loop exits
+	listening   bool          // the event loop has been started
+	pools       []*workerPool // in configuration order
+	subscribers map[string][]chan *Task
+	errorCounts map[string]int // number of failed attempts by error kind
+	beat        *beat
+	workflows   map[string]*Workflow
+	dedupIndex  map[string]string // dedup. key => task ID
+	argsSchemas map[string]*jsonSchema
+	journal     *journal
+	draining    bool
+
+	// doneWorkflowTasks contains finished tasks
+	// belonging to workflows which have not been
+	// advanced yet (see advanceWorkflows)
+	doneWorkflowTasks []*Task
+}
+
+// newQueues creates task queues based on configuration.
+// The default queue is always present.
+func newQueues(conf *MasterConf) []*taskQueue {
+	queues := make([]*taskQueue, 0, len(conf.Queues)+1)
+	hasDefault := false
+	for _, qc := range conf.Queues {
+		maxLength := conf.MaxQueueLength
+		if qc.MaxLength > 0 {
+			maxLength = qc.MaxLength
+		}
+		queues = append(queues, newTaskQueue(qc.Name, qc.Priority, maxLength))
+		if qc.Name == defaultQueueName {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		queues = append(queues, newTaskQueue(defaultQueueName, 0, conf.MaxQueueLength))
+	}
+	sort.SliceStable(queues, func(i, j int) bool {
+		return queues[i].priority > queues[j].priority
+	})
+	return queues
 }
 
 // NewMaster is a standard constructor for Master
-func NewMaster(conf *MasterConf) *Master {
-	return &Master{
+func NewMaster(conf *MasterConf, tasks TaskStore) *Master {
+	maxWorkers := conf.maxWorkers()
+	m := &Master{
 		conf:        conf,
 		workers:     make(map[*Worker]*Task),
-		tasks:       make(map[string]*Task),
-		queueEvent:  make(chan bool, conf.PoolSize*10),
-		queue:       make(chan *Task, conf.PoolSize),
-		workerEvent: make(chan *WorkerStatus, conf.PoolSize*10),
+		tasks:       tasks,
+		queueEvent:  make(chan bool, maxWorkers*10),
+		queues:      newQueues(conf),
+		pools:       newWorkerPools(conf),
+		scheduler:   newTaskScheduler(),
+		workerEvent: make(chan *WorkerStatus, maxWorkers*10),
 		mutex:       &sync.Mutex{},
+		stop:        make(chan bool, 1),
+		stopped:     make(chan bool),
+		subscribers: make(map[string][]chan *Task),
+		errorCounts: make(map[string]int),
+		workflows:   make(map[string]*Workflow),
+		dedupIndex:  make(map[string]string),
+		argsSchemas: make(map[string]*jsonSchema),
+	}
+	for fn, data := range conf.ArgsSchemas {
+		schema, err := parseSchema(data)
+		if err != nil {
+			log.Printf("ERROR: invalid args schema of %s, ignoring: %s", fn, err)
+			continue
+		}
+		m.argsSchemas[fn] = schema
 	}
+	m.beat = newBeat(m, conf.PeriodicTasks)
+	return m
 }
 
 // Info returns overview information used
 // on the "info" page of the API server.
 func (m *Master) Info() *MasterInfo {
-	workersInfo := make([]WorkerInfo, len(m.workers))
-	i := 0
+	// beat calls SendTask with its own lock held so it
+	// must not be accessed with Master's lock held
+	periodicTasks := m.beat.info()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	workersInfo := make([]WorkerInfo, 0, len(m.workers))
+	pools := make([]PoolInfo, len(m.pools))
+	poolIdx := make(map[*workerPool]int, len(m.pools))
+	var minPoolSize, maxPoolSize, recycled int
+	for i, pool := range m.pools {
+		minSize, maxSize := pool.conf.limits()
+		pools[i] = PoolInfo{
+			Name:        pool.conf.Name,
+			Program:     pool.conf.Program,
+			MinSize:     minSize,
+			MaxSize:     maxSize,
+			Recycled:    pool.recycled,
+			WorkersInfo: make([]WorkerInfo, 0, maxSize),
+		}
+		poolIdx[pool] = i
+		minPoolSize += minSize
+		maxPoolSize += maxSize
+		recycled += pool.recycled
+	}
 	for worker := range m.workers {
-		workersInfo[i] = worker.Info()
-		i++
+		info := worker.Info()
+		workersInfo = append(workersInfo, info)
+		pi := &pools[poolIdx[worker.pool]]
+		pi.WorkersInfo = append(pi.WorkersInfo, info)
+		pi.Size++
+	}
+	queues := make([]QueueInfo, len(m.queues))
+	for i, q := range m.queues {
+		queues[i] = QueueInfo{
+			Name:      q.name,
+			Priority:  q.priority,
+			Length:    q.size(),
+			MaxLength: q.maxLength,
+		}
+	}
+	errorCounts := make(map[string]int, len(m.errorCounts))
+	for kind, count := range m.errorCounts {
+		errorCounts[kind] = count
 	}
 	return &MasterInfo{
-		PoolSize:    len(m.workers),
-		WorkersInfo: workersInfo,
+		PoolSize:      len(m.workers),
+		MinPoolSize:   minPoolSize,
+		MaxPoolSize:   maxPoolSize,
+		Recycled:      recycled,
+		WorkersInfo:   workersInfo,
+		Pools:         pools,
+		Queues:        queues,
+		Scheduled:     m.scheduler.size(),
+		ErrorCounts:   errorCounts,
+		PeriodicTasks: periodicTasks,
 	}
 }
 
-// getFreeWorker returns a free Worker if available.
-// Otherwise, nil is returned.
-func (m *Master) getFreeWorker() *Worker {
+// getFreeWorker returns a free Worker of a specified pool
+// if available. Otherwise, nil is returned.
+func (m *Master) getFreeWorker(pool *workerPool) *Worker {
 	for w, t := range m.workers {
-		if t == nil {
+		if t == nil && w.pool == pool && !w.isDown() {
 			return w
 		}
 	}
 	return nil
 }
 
-// executeNextTask fetches a next task from
-// internal queue and executes it. In case
-// there is no task enqueued, nothing is done.
-func (m *Master) executeNextTask() {
-	worker := m.getFreeWorker()
-	if worker != nil {
-		select {
-		case task := <-m.queue:
-			log.Print("INFO: dequed task ", task)
-			if task != nil {
-				m.workers[worker] = task
-				task.Status = taskStatusRunning
-				worker.Call(task.TaskID, task.Fn, task.Args)
+// numPoolWorkers returns number of running
+// workers of a specified pool
+func (m *Master) numPoolWorkers(pool *workerPool) int {
+	ans := 0
+	for w := range m.workers {
+		if w.pool == pool {
+			ans++
+		}
+	}
+	return ans
+}
+
+// getPool returns a pool with a specified name.
+// If there is no such pool, nil is returned.
+func (m *Master) getPool(name string) *workerPool {
+	for _, pool := range m.pools {
+		if pool.conf.Name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// routePool finds a pool for a specified task function
+// based on configured pool routes.
+func (m *Master) routePool(fn string) *workerPool {
+	for _, route := range m.conf.PoolRoutes {
+		if route.matches(fn) {
+			if pool := m.getPool(route.Pool); pool != nil {
+				return pool
 			}
-		default:
+			log.Printf("ERROR: unknown pool %s in route for %s", route.Pool, fn)
+		}
+	}
+	if pool := m.getPool(defaultPoolName); pool != nil {
+		return pool
+	}
+	return m.pools[0]
+}
+
+// taskPool returns a pool the task is supposed to be
+// executed by. In case the task's pool is unknown (e.g. a restored
+// task with configuration changed in the meantime), the task
+// is routed again.
+func (m *Master) taskPool(task *Task) *workerPool {
+	pool := m.getPool(task.Pool)
+	if pool == nil {
+		pool = m.routePool(task.Fn)
+		task.Pool = pool.conf.Name
+	}
+	return pool
+}
+
+// acquireWorker returns a free worker of a specified pool.
+// In case there is no free worker and the pool is allowed
+// to grow, a new worker is spawned. Otherwise nil is returned.
+func (m *Master) acquireWorker(pool *workerPool) *Worker {
+	worker := m.getFreeWorker(pool)
+	if _, maxSize := pool.conf.limits(); worker == nil && m.numPoolWorkers(pool) < maxSize {
+		worker = m.spawnWorker(pool)
+		if worker.isDown() {
+			return nil
+		}
+	}
+	return worker
+}
+
+// getTaskWorker returns a Worker currently
+// processing a specified task. If there is
+// no such worker, nil is returned.
+func (m *Master) getTaskWorker(task *Task) *Worker {
+	for w, t := range m.workers {
+		if t == task {
+			return w
+		}
+	}
+	return nil
+}
+
+// getQueue returns a queue with a specified name.
+// If there is no such queue, nil is returned.
+func (m *Master) getQueue(name string) *taskQueue {
+	for _, q := range m.queues {
+		if q.name == name {
+			return q
+		}
+	}
+	return nil
+}
+
+// routeTask finds a queue for a specified task function
+// based on configured function name prefixes.
+func (m *Master) routeTask(fn string) *taskQueue {
+	for _, qc := range m.conf.Queues {
+		for _, prefix := range qc.FnPrefixes {
+			if strings.HasPrefix(fn, prefix) {
+				return m.getQueue(qc.Name)
+			}
+		}
+	}
+	return m.getQueue(defaultQueueName)
+}
+
+// enqueue puts a task into its queue. In case
+// the queue of the task is unknown (e.g. a restored
+// task with configuration changed in the meantime),
+// the task is routed again.
+func (m *Master) enqueue(task *Task) {
+	queue := m.getQueue(task.Queue)
+	if queue == nil {
+		queue = m.routeTask(task.Fn)
+		task.Queue = queue.name
+	}
+	queue.push(task)
+}
+
+// dequeue removes a task from its queue
+func (m *Master) dequeue(task *Task) {
+	for _, q := range m.queues {
+		if q.remove(task.TaskID) {
+			return
+		}
+	}
+}
+
+// signalQueue notifies the event loop about new waiting
+// task(s). The function never blocks - in case the event
+// buffer is full, waiting tasks are dispatched during
+// the next periodic check.
+func (m *Master) signalQueue() {
+	select {
+	case m.queueEvent <- true:
+	default:
+	}
+}
+
+// dispatchWaitingTasks assigns waiting tasks to free workers
+func (m *Master) dispatchWaitingTasks() {
+	for i := m.numWaitingTasks(); i > 0; i-- {
+		m.executeNextTask()
+	}
+}
+
+// numWaitingTasks returns number of tasks in all the queues
+func (m *Master) numWaitingTasks() int {
+	ans := 0
+	for _, q := range m.queues {
+		ans += q.size()
+	}
+	return ans
+}
+
+// spawnWorker creates and starts a new worker of a specified pool
+func (m *Master) spawnWorker(pool *workerPool) *Worker {
+	args := make([]string, len(pool.conf.ProgramArgs), len(pool.conf.ProgramArgs)+1)
+	copy(args, pool.conf.ProgramArgs)
+	args = append(args, fmt.Sprintf("W%d", pool.workerSeq))
+	pool.workerSeq++
+	worker := NewWorker(m.workerEvent, m.conf.MaxResponsePipeBufferSize, m.conf.StderrBufferLines,
+		pool.conf.Program, args...)
+	worker.pool = pool
+	m.workers[worker] = nil
+	if m.startWorker(worker) {
+		log.Printf("INFO: started worker %v in pool %s", worker, pool.conf.Name)
+	}
+	return worker
+}
+
+// startWorker starts a worker process. In case the process
+// cannot be started, the worker is marked as down and another
+// attempt is postponed (see startPostponedWorkers).
+func (m *Master) startWorker(worker *Worker) bool {
+	worker.restartAt = time.Time{}
+	err := worker.Start()
+	if err != nil {
+		log.Printf("ERROR: failed to start worker in pool %s: %s", worker.pool.conf.Name, err)
+		worker.crashes++
+		m.postponeWorkerStart(worker)
+		return false
+	}
+	return true
+}
+
+// postponeWorkerStart marks a worker as down until
+// its restart delay elapses
+func (m *Master) postponeWorkerStart(worker *Worker) {
+	delay := worker.restartDelay()
+	if delay < workerRestartMinDelay {
+		delay = workerRestartMinDelay
+	}
+	worker.restartAt = time.Now().Add(delay)
+	log.Printf("WARNING: worker in pool %s will be started again in %v", worker.pool.conf.Name, delay)
+}
+
+// startPostponedWorkers starts workers which are down
+// and their restart delay has elapsed
+func (m *Master) startPostponedWorkers() {
+	now := time.Now()
+	for worker := range m.workers {
+		if worker.isDown() && now.After(worker.restartAt) && m.startWorker(worker) {
+			log.Printf("INFO: started worker %v in pool %s again", worker, worker.pool.conf.Name)
 		}
 	}
 }
 
+// releaseWorker marks a worker as free
+func (m *Master) releaseWorker(worker *Worker) {
+	m.workers[worker] = nil
+	worker.idleSince = time.Now()
+}
+
+// recycleWorkerIfNeeded replaces a worker process which
+// has executed too many tasks or which uses too much memory
+// with a fresh one.
+func (m *Master) recycleWorkerIfNeeded(worker *Worker) {
+	var reason string
+	poolConf := &worker.pool.conf
+	if poolConf.MaxTasksPerWorker > 0 && worker.tasksDone >= poolConf.MaxTasksPerWorker {
+		reason = fmt.Sprintf("%d tasks executed", worker.tasksDone)
+
+	} else if poolConf.MaxWorkerRSSBytes > 0 {
+		rss, err := worker.RSS()
+		if err != nil {
+			log.Printf("ERROR: failed to get RSS of %v: %s", worker, err)
+
+		} else if rss > poolConf.MaxWorkerRSSBytes {
+			reason = fmt.Sprintf("RSS %d bytes", rss)
+		}
+	}
+	if reason != "" {
+		worker.Stop()
+		m.startWorker(worker)
+		worker.recycled++
+		worker.pool.recycled++
+		log.Printf("INFO: recycled worker %v (%s)", worker, reason)
+	}
+}
+
+// checkHeartbeats pings idle workers and restarts
+// the ones which missed too many pings
+func (m *Master) checkHeartbeats() {
+	if m.conf.HeartbeatIntervalSeconds <= 0 {
+		return
+	}
+	maxMissed := m.conf.HeartbeatMaxMissed
+	if maxMissed <= 0 {
+		maxMissed = defaultHeartbeatMaxMissed
+	}
+	interval := time.Duration(m.conf.HeartbeatIntervalSeconds) * time.Second
+	for worker, task := range m.workers {
+		if task != nil || worker.isDown() || time.Since(worker.lastPing) < interval {
+			continue
+		}
+		if worker.pingMissed() {
+			worker.missedHeartbeats++
+
+		} else {
+			worker.missedHeartbeats = 0
+		}
+		if worker.missedHeartbeats >= maxMissed {
+			log.Printf("WARNING: worker %v missed %d heartbeat(s), restarting", worker, worker.missedHeartbeats)
+			m.restartWorker(worker)
+			continue
+		}
+		worker.Ping()
+	}
+}
+
+// retireIdleWorkers stops workers which have been idle
+// for too long as long as their pool is larger than
+// its minimal size.
+func (m *Master) retireIdleWorkers() {
+	if m.numWaitingTasks() > 0 {
+		return
+	}
+	for _, pool := range m.pools {
+		if !pool.conf.IsElastic() {
+			continue
+		}
+		minSize, _ := pool.conf.limits()
+		numWorkers := m.numPoolWorkers(pool)
+		idleTimeout := pool.conf.idleTimeout()
+		for worker, task := range m.workers {
+			if numWorkers <= minSize {
+				break
+			}
+			if worker.pool == pool && task == nil && time.Since(worker.idleSince) > idleTimeout {
+				worker.Stop()
+				delete(m.workers, worker)
+				numWorkers--
+				log.Printf("INFO: stopped idle worker %v in pool %s", worker, pool.conf.Name)
+			}
+		}
+	}
+}
+
+// executeNextTask fetches a next task from the
+// highest-priority queue which contains a task
+// its pool can execute right now and executes it.
+// In case there is no free worker in the pool and the pool
+// is allowed to grow, a new worker is spawned.
+// In case there is no such task enqueued, nothing is done.
+func (m *Master) executeNextTask() {
+	if m.draining {
+		return
+	}
+	workers := make(map[*workerPool]*Worker)
+	canExecute := func(task *Task) bool {
+		pool := m.taskPool(task)
+		worker, ok := workers[pool]
+		if !ok {
+			worker = m.acquireWorker(pool)
+			workers[pool] = worker
+		}
+		return worker != nil
+	}
+	for _, q := range m.queues {
+		task := q.popMatching(canExecute)
+		if task == nil {
+			continue
+		}
+		worker := workers[m.taskPool(task)]
+		log.Print("INFO: dequed task ", task)
+		m.journalTask(journalDequeued, task, 0)
+		m.workers[worker] = task
+		task.Status = taskStatusRunning
+		task.Attempt++
+		task.Started = time.Now().Unix()
+		task.Progress = nil
+		task.Stderr = nil
+		task.softLimitSent = false
+		m.saveTask(task)
+		m.journalTask(journalStarted, task, worker.GetPID())
+		worker.Call(task.TaskID, task.Fn, task.Args)
+		return
+	}
+}
+
+// restartWorker kills a worker process and starts
+// a new one. A task processed by the worker (if any)
+// is detached from the worker but its status
+// is left untouched.
+func (m *Master) restartWorker(worker *Worker) {
+	m.releaseWorker(worker)
+	worker.Stop() // TODO what if this takes a long time???
+	if m.startWorker(worker) {
+		log.Printf("WARNING: restarted worker %v", worker)
+	}
+}
+
+// restartCrashedWorker replaces a worker process which
+// exited unexpectedly. Consecutive crashes postpone the restart
+// (see Worker.restartDelay) so a broken worker program does not
+// end up in a busy respawn loop.
+func (m *Master) restartCrashedWorker(worker *Worker) {
+	if time.Since(worker.startedAt) > workerStableUptime {
+		worker.crashes = 0
+	}
+	worker.crashes++
+	if worker.restartDelay() > 0 {
+		m.releaseWorker(worker)
+		worker.Stop()
+		m.postponeWorkerStart(worker)
+		return
+	}
+	m.restartWorker(worker)
+}
+
 func (m *Master) checkForStuckWorkers() {
 	for worker, task := range m.workers {
-		if task != nil && task.AgeSecons() > m.conf.ExecMaxSeconds {
-			log.Print("checking task ", time.Now().Unix(), task.Created, task.AgeSecons(), m.conf.ExecMaxSeconds)
-			//m.mutex.Lock()
-			m.workers[worker] = nil
-			worker.Stop() // TODO what if this takes a long time???
-			worker.Start()
-			task.Error = "Task execution limit reached"
-			task.Status = taskStatusFinished
-			task.Touch()
-			//m.mutex.Unlock()
-			log.Printf("WARNING: restarted stuck worker %v", worker)
-			m.queueEvent <- true
+		if task == nil {
+			continue
+		}
+		limit := m.conf.execLimit(task.Fn, &worker.pool.conf)
+		if task.RunningSeconds() > limit.ExecMaxSeconds {
+			log.Print("checking task ", time.Now().Unix(), task.Started, task.RunningSeconds(), limit.ExecMaxSeconds)
+			task.Stderr = worker.TaskStderr()
+			pid := worker.GetPID()
+			m.restartWorker(worker)
+			m.failTask(task, &TaskError{
+				Kind:      errorKindTimeout,
+				Message:   "Task execution limit reached",
+				WorkerPID: pid,
+			})
+			m.signalQueue()
+
+		} else if limit.SoftExecMaxSeconds > 0 && !task.softLimitSent &&
+			task.RunningSeconds() > limit.SoftExecMaxSeconds {
+			log.Printf("WARNING: task %s reached soft execution limit, notifying %v", task.TaskID, worker)
+			worker.SignalSoftLimit()
+			task.softLimitSent = true
 		}
 	}
 }
 
 func (m *Master) checkForOldTasks() {
-	for taskID, task := range m.tasks {
+	for _, task := range m.tasks.List() {
 		if task.IsDone() && task.SecondsSinceUpdate() > m.conf.TaskResultPersistMaxSeconds {
-			log.Print("DELETE TASK >>>>>>>> ", taskID)
-			delete(m.tasks, taskID)
+			log.Print("DELETE TASK >>>>>>>> ", task.TaskID)
+			if m.dedupIndex[task.DedupKey] == task.TaskID {
+				delete(m.dedupIndex, task.DedupKey)
+			}
+			m.removeResultFile(task)
+			m.journalTask(journalExpired, task, 0)
+			err := m.tasks.Delete(task.TaskID)
+			if err != nil {
+				log.Printf("ERROR: failed to delete task %s: %s", task.TaskID, err)
+			}
+		}
+	}
+	now := time.Now().Unix()
+	for workflowID, wf := range m.workflows {
+		if wf.IsDone() && now-wf.Updated > int64(m.conf.TaskResultPersistMaxSeconds) {
+			delete(m.workflows, workflowID)
 		}
 	}
 }
 
+// failTask handles a task which ended with an error.
+// If a retry policy of the task's function allows it, the task
+// is scheduled for another attempt. Otherwise it is marked
+// as failed.
+func (m *Master) failTask(task *Task, taskErr *TaskError) {
+	task.Error = taskErr.Message
+	task.ErrorDetail = taskErr
+	task.Touch()
+	m.errorCounts[taskErr.Kind]++
+	policy, ok := m.conf.RetryPolicies[task.Fn]
+	if ok && task.Attempt < task.MaxAttempts && policy.isRetryable(taskErr.Kind) {
+		delay := policy.backoff(task.Attempt)
+		m.scheduleTask(task, time.Now().Add(delay))
+		m.journalTask(journalRetried, task, taskErr.WorkerPID)
+		log.Printf("WARNING: task %s failed (%s), attempt %d of %d will start in %v",
+			task.TaskID, taskErr, task.Attempt+1, task.MaxAttempts, delay)
+		return
+	}
+	task.Status = taskStatusFailed
+	m.saveTask(task)
+	m.journalTask(journalFailed, task, taskErr.WorkerPID)
+	log.Printf("INFO: task %s failed (%s)", task.TaskID, taskErr)
+}
+
+// scheduleTask postpones execution of a task
+// until a specified time. The task is scheduled
+// even if it cannot be stored.
+func (m *Master) scheduleTask(task *Task, eta time.Time) error {
+	task.Status = taskStatusScheduled
+	task.ETA = eta.Unix()
+	if eta.Nanosecond() > 0 {
+		task.ETA++ // never run a task before its ETA
+	}
+	m.scheduler.push(task)
+	return m.saveTask(task)
+}
+
+// checkScheduledTasks moves all the scheduled tasks
+// with ETA reached to their queues and starts them
+// if there are free workers.
+func (m *Master) checkScheduledTasks() {
+	due := m.scheduler.popDue(time.Now().Unix())
+	for _, task := range due {
+		task.Status = taskStatusWaiting
+		m.saveTask(task)
+		m.enqueue(task)
+		log.Printf("INFO: scheduled task %s enqueued", task.TaskID)
+	}
+	for range due {
+		m.executeNextTask()
+	}
+}
+
+// handleWorkerResult processes a final response
+// of a worker (either a task result or an error).
+func (m *Master) handleWorkerResult(v *WorkerStatus) {
+	worker := v.Worker()
+	currTask := m.workers[worker]
+	if v.crashed {
+		// the process is gone, we need a new one
+		m.restartCrashedWorker(worker)
+		if currTask == nil {
+			log.Printf("WARNING: idle worker process %d exited", v.pid)
+			return
+		}
+
+	} else if currTask == nil {
+		log.Printf("WARNING: ignoring response of idle worker %v", worker)
+		return
+	}
+	// the worker process (stale events are filtered out
+	// already) executes only the current task
+	v.TaskID = currTask.TaskID
+	task := m.tasks.Get(v.TaskID)
+	if task == nil {
+		log.Printf("ERROR: worker event no longer valid (task \"%s\" gone)", v.TaskID)
+		// the worker is done with the task anyway
+		m.releaseWorker(worker)
+		return
+	}
+	if task != currTask || task.Status != taskStatusRunning {
+		// e.g. a cancelled task or a task killed after reaching
+		// the execution limit - the worker's late answer is ignored
+		log.Printf("INFO: ignoring worker event for task %s which is no longer running", task.TaskID)
+		return
+	}
+	m.releaseWorker(worker)
+	if v.crashed {
+		task.Stderr = v.stderr
+		// a crashed worker has no chance to report its traceback
+		// but it is typically written to stderr
+		m.failTask(task, &TaskError{
+			Kind:      errorKindCrash,
+			Message:   v.Error,
+			Traceback: v.stderr,
+			WorkerPID: v.pid,
+			ExitCode:  v.exitCode,
+		})
+
+	} else if v.Error != "" {
+		task.Stderr = v.stderr
+		kind := v.ErrorKind
+		if kind == "" {
+			kind = errorKindApplication
+		}
+		m.failTask(task, &TaskError{
+			Kind:      kind,
+			Message:   v.Error,
+			Traceback: v.Traceback,
+			WorkerPID: worker.GetPID(),
+		})
+
+	} else if v.ResultFile != "" {
+		err := m.storeResultFile(task, v.ResultFile)
+		if err != nil {
+			log.Printf("ERROR: failed to store result file of task %s: %s", task.TaskID, err)
+			m.failTask(task, &TaskError{
+				Kind:      errorKindInternal,
+				Message:   fmt.Sprintf("Failed to store result file: %s", err),
+				WorkerPID: worker.GetPID(),
+			})
+
+		} else {
+			task.Error = ""
+			task.ErrorDetail = nil
+			task.Status = taskStatusFinished
+			task.Touch()
+			m.saveTask(task)
+			m.journalTask(journalFinished, task, worker.GetPID())
+			log.Printf("INFO: task %s finished (result file, %d bytes).", task.TaskID, task.ResultSize)
+		}
+
+	} else {
+		task.Error = ""
+		task.ErrorDetail = nil
+		task.Status = taskStatusFinished
+		task.Result = v.Result
+		task.Touch()
+		m.saveTask(task)
+		m.journalTask(journalFinished, task, worker.GetPID())
+		log.Printf("INFO: task %s finished.", task.TaskID)
+	}
+	if !v.crashed {
+		worker.tasksDone++
+		m.recycleWorkerIfNeeded(worker)
+	}
+}
+
+// handleWorkerProgress processes a non-final status
+// of a worker (typically a progress update).
+func (m *Master) handleWorkerProgress(v *WorkerStatus) {
+	task := m.workers[v.Worker()]
+	if task == nil {
+		log.Print("INFO: updated status of worker ", v)
+		return
+	}
+	v.TaskID = task.TaskID
+	if v.Progress != nil {
+		task.Progress = v.Progress
+		task.Touch()
+		m.saveTask(task)
+	}
+}
+
+// saveTask persists changes made to a task
+// and notifies the task's subscribers. In case
+// the task cannot be stored, the error is logged
+// and returned (the subscribers are not notified).
+func (m *Master) saveTask(task *Task) error {
+	err := m.tasks.Put(task)
+	if err != nil {
+		log.Printf("ERROR: failed to store task %s: %s", task.TaskID, err)
+		return err
+	}
+	if task.WorkflowID != "" && task.IsDone() {
+		m.doneWorkflowTasks = append(m.doneWorkflowTasks, task)
+	}
+	m.notifySubscribers(task)
+	return nil
+}
+
+// notifySubscribers sends a copy of a task to
+// all the channels subscribed to the task. Slow
+// subscribers (with their channel full) miss
+// the update. The final state of a task is always
+// delivered - it replaces the oldest buffered update.
+func (m *Master) notifySubscribers(task *Task) {
+	for _, ch := range m.subscribers[task.TaskID] {
+		select {
+		case ch <- task.clone():
+			continue
+		default:
+		}
+		if !task.IsDone() {
+			log.Printf("WARNING: subscriber of task %s too slow, update dropped", task.TaskID)
+			continue
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- task.clone():
+		default:
+			log.Printf("ERROR: failed to deliver final state of task %s to a subscriber", task.TaskID)
+		}
+	}
+}
+
+// isQueued tests whether a task is already
+// in one of the queues
+func (m *Master) isQueued(task *Task) bool {
+	for _, q := range m.queues {
+		if q.contains(task.TaskID) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTasks puts back to the queue all the waiting
+// tasks found in the task store (e.g. the ones left there
+// by a previous instance of Master before reload). Scheduled
+// tasks are returned to the scheduler. Tasks marked as running
+// were interrupted along with their worker so they are finished
+// with an error. Tasks submitted before Start are already queued
+// (or scheduled) so they are skipped. The function returns number
+// of restored waiting tasks.
+func (m *Master) restoreTasks() int {
+	waiting := make([]*Task, 0, 10)
+	for _, task := range m.tasks.List() {
+		if task.DedupKey != "" {
+			m.dedupIndex[task.DedupKey] = task.TaskID
+		}
+		switch task.Status {
+		case taskStatusWaiting:
+			if !m.isQueued(task) {
+				waiting = append(waiting, task)
+			}
+		case taskStatusScheduled:
+			if !m.scheduler.contains(task.TaskID) {
+				m.scheduler.push(task)
+			}
+		case taskStatusRunning:
+			m.failTask(task, &TaskError{
+				Kind:    errorKindInterrupted,
+				Message: "Task interrupted by konserver restart",
+			})
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].Created < waiting[j].Created
+	})
+	for _, task := range waiting {
+		m.enqueue(task)
+	}
+	if len(waiting) > 0 {
+		log.Printf("INFO: restored %d waiting task(s)", len(waiting))
+	}
+	return len(waiting)
+}
+
 // listenForEvents starts a goroutine listening
 // for changes Master interprets as triggers
 // to start another task (e.g. "new task has been
 // added", "existing task has finished").
 func (m *Master) listenForEvents() {
 	go func() {
+		// the periodic checks must not depend on a pause
+		// in events (progress updates may come all the time)
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		defer close(m.stopped)
 		for {
 			select {
+			case <-m.stop:
+				return
 			case v := <-m.queueEvent:
 				if v {
+					m.mutex.Lock()
 					m.executeNextTask()
+					m.mutex.Unlock()
 
 				} else {
 					log.Print("INFO: removed task")
 				}
 			case v := <-m.workerEvent:
-				if v.IsDone() {
-					task, ok := m.tasks[v.TaskID]
-					if !ok {
-						log.Printf("ERROR: worker event no longer valid (task \"%s\" gone)", v.TaskID)
-
-					} else {
-						if v.IsDone() {
-							task.Error = v.Error
-							task.Status = taskStatusFinished
-							task.Result = v.Result
-							m.workers[v.Worker()] = nil
-							log.Printf("INFO: task %s finished.", task.TaskID)
-						}
-						task.Touch()
-					}
-					m.queueEvent <- true
+				m.mutex.Lock()
+				stale := v.isStale()
+				m.mutex.Unlock()
+				if stale {
+					log.Printf("INFO: ignoring event of a replaced process of worker %v", v.Worker())
+
+				} else if v.Control == controlHello {
+					m.mutex.Lock()
+					m.registerFunctions(v.Worker(), v.Functions)
+					m.mutex.Unlock()
+
+				} else if v.IsDone() {
+					m.mutex.Lock()
+					m.handleWorkerResult(v)
+					m.advanceWorkflows()
+					m.mutex.Unlock()
+					m.signalQueue()
 
 				} else {
-					log.Print("INFO: updated status of worker", v)
-					// TODO
+					m.mutex.Lock()
+					m.handleWorkerProgress(v)
+					m.mutex.Unlock()
 				}
-			case <-time.After(1 * time.Second):
+			case <-ticker.C:
+				m.mutex.Lock()
 				m.checkForStuckWorkers()
+				m.checkScheduledTasks()
 				m.checkForOldTasks()
+				m.retireIdleWorkers()
+				m.startPostponedWorkers()
+				m.checkHeartbeats()
+				m.advanceWorkflows()
+				m.dispatchWaitingTasks()
+				m.mutex.Unlock()
 			}
 		}
 	}()
@@ -201,29 +1097,46 @@ func (m *Master) listenForEvents() {
 // and starts to listen for tasks. The function
 // is non-blocking.
 func (m *Master) Start() {
-	for i := 0; i < m.conf.PoolSize; i++ {
-		args := append(m.conf.ProgramArgs, fmt.Sprintf("W%d", i))
-		worker := NewWorker(m.workerEvent, m.conf.MaxResponsePipeBufferSize, m.conf.Program, args...)
-		m.workers[worker] = nil
-		worker.Start() // TODO we must catch errors in worker via a channel
-		/*
-			if werr != nil {
-				m.Stop()
-				log.Printf("ERROR: failed to run worker %d: %s", i, werr) // TODO
-			}
-		*/
-		log.Printf("INFO: started worker %v", worker)
+	m.mutex.Lock()
+	for _, pool := range m.pools {
+		minSize, _ := pool.conf.limits()
+		for i := 0; i < minSize; i++ {
+			m.spawnWorker(pool)
+		}
 	}
+	m.openJournal()
+	numRestored := m.restoreTasks()
+	m.listening = true
+	m.mutex.Unlock()
 	m.listenForEvents()
+	for i := 0; i < numRestored; i++ {
+		m.signalQueue()
+	}
+	m.beat.start()
 }
 
-// Stop stops all the workers
+// Stop stops listening for events and
+// stops all the workers. The function waits
+// for the event loop to finish first so no
+// worker is started or stopped concurrently.
 func (m *Master) Stop() {
+	m.beat.close()
+	m.mutex.Lock()
+	listening := m.listening
+	m.mutex.Unlock()
+	if listening {
+		m.stop <- true
+		<-m.stopped
+	}
+	m.mutex.Lock()
 	for w := range m.workers {
-		if w != nil {
-			w.Stop()
-		}
+		w.Stop()
 	}
+	if m.journal != nil {
+		m.journal.close()
+		m.journal = nil
+	}
+	m.mutex.Unlock()
 	// TODO stop also listener for tasks etc.
 }
 
@@ -235,41 +1148,478 @@ func (m *Master) Reload() {
 	}
 }
 
-// GetTask returns a specific task identified
+// GetTask returns a copy of a specific task identified
 // by task ID. In case there is no such task,
 // nil is returned.
 func (m *Master) GetTask(taskID string) *Task {
-	for _, task := range m.tasks {
-		if task.TaskID == taskID {
-			return task
-		}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if task := m.tasks.Get(taskID); task != nil {
+		return task.clone()
 	}
 	return nil
 }
 
-// SendTask sends a new task to Master
-func (m *Master) SendTask(name string, jsonArgs []byte) *Task {
-	log.Printf("Received task %s with args %s", name, string(jsonArgs))
-	taskID, err := uuid.NewV4()
-	if err != nil {
-		// TODO
-		log.Print("ERROR: ", err)
+// CancelTask cancels a task identified by taskID.
+// A waiting task is removed from the queue, a running
+// one is aborted by restarting its worker. Already
+// finished tasks are left untouched. A copy of the task
+// is returned. In case there is no such task, nil is returned.
+func (m *Master) CancelTask(taskID string) *Task {
+	m.mutex.Lock()
+	task := m.tasks.Get(taskID)
+	if task == nil {
+		m.mutex.Unlock()
+		return nil
 	}
-	var args interface{}
-	err = json.Unmarshal(jsonArgs, &args)
-	if err != nil {
-		log.Print("ERROR: ", err)
+	switch task.Status {
+	case taskStatusWaiting:
+		m.dequeue(task)
+	case taskStatusScheduled:
+		m.scheduler.remove(taskID)
+	case taskStatusRunning:
+		if worker := m.getTaskWorker(task); worker != nil {
+			m.restartWorker(worker)
+		}
+	default:
+		m.mutex.Unlock()
+		return task.clone()
+	}
+	task.Status = taskStatusCancelled
+	task.Touch()
+	m.saveTask(task)
+	m.journalTask(journalCancelled, task, 0)
+	m.advanceWorkflows()
+	ans := task.clone()
+	m.mutex.Unlock()
+	log.Printf("INFO: task %s cancelled", taskID)
+	m.signalQueue()
+	return ans
+}
+
+// Subscribe registers a channel which will receive
+// a copy of a specified task each time the task changes
+// (status, progress etc.). The current state of the task
+// is sent immediately. Please note that the channel should
+// be buffered as Master never blocks when sending updates.
+func (m *Master) Subscribe(taskID string, ch chan *Task) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subscribers[taskID] = append(m.subscribers[taskID], ch)
+	if task := m.tasks.Get(taskID); task != nil {
+		select {
+		case ch <- task.clone():
+		default:
+		}
+	}
+}
+
+// Unsubscribe removes a channel registered via Subscribe
+func (m *Master) Unsubscribe(taskID string, ch chan *Task) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	subs := m.subscribers[taskID]
+	for i, sub := range subs {
+		if sub == ch {
+			subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(m.subscribers, taskID)
+
+	} else {
+		m.subscribers[taskID] = subs
+	}
+}
+
+// newTask creates a new task routed to a proper queue.
+// The task is not submitted yet.
+func (m *Master) newTask(name string, args interface{}, opts *TaskOptions) (*Task, *taskQueue, error) {
+	var queue *taskQueue
+	if opts.Queue != "" {
+		queue = m.getQueue(opts.Queue)
+		if queue == nil {
+			return nil, nil, fmt.Errorf("unknown queue %s", opts.Queue)
+		}
+
+	} else {
+		queue = m.routeTask(name)
+	}
+	taskID := opts.TaskID
+	if taskID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, nil, err
+		}
+		taskID = id.String()
 	}
 	task := &Task{
-		TaskID:  taskID.String(),
-		Status:  taskStatusWaiting,
-		Fn:      name,
-		Args:    args,
-		Created: time.Now().Unix(),
-	}
-	m.tasks[task.TaskID] = task
-	m.queue <- task
+		TaskID:      taskID,
+		Status:      taskStatusWaiting,
+		Fn:          name,
+		Args:        args,
+		Created:     time.Now().Unix(),
+		Queue:       queue.name,
+		Pool:        m.routePool(name).conf.Name,
+		Priority:    queue.priority,
+		Submitter:   opts.Submitter,
+		MaxAttempts: 1,
+	}
+	if opts.Priority != nil {
+		task.Priority = *opts.Priority
+	}
+	if policy, ok := m.conf.RetryPolicies[name]; ok && policy.MaxAttempts > 1 {
+		task.MaxAttempts = policy.MaxAttempts
+	}
+	return task, queue, nil
+}
+
+// submitTask stores a new task and either schedules it
+// (in case its ETA is in the future) or pushes it to
+// its queue. The returned value says whether the task
+// has been enqueued (i.e. whether a worker should be
+// looked for). In case the task cannot be stored, it is
+// neither scheduled nor enqueued and the error is returned.
+func (m *Master) submitTask(task *Task, queue *taskQueue, eta time.Time) (bool, error) {
+	if eta.After(time.Now()) {
+		if err := m.scheduleTask(task, eta); err != nil {
+			m.scheduler.remove(task.TaskID)
+			return false, err
+		}
+		m.journalTask(journalSubmitted, task, 0)
+		log.Printf("INFO: task %s scheduled for %s", task.TaskID, eta.Format(time.RFC3339))
+		return false, nil
+	}
+	if err := m.saveTask(task); err != nil {
+		return false, err
+	}
+	m.journalTask(journalSubmitted, task, 0)
+	queue.push(task)
 	log.Print("INFO: >>>> ENQUEUED TASK ", task)
-	m.queueEvent <- true
+	return true, nil
+}
+
+// dedupKey returns a deduplication key of a new task.
+// An empty string is returned in case the task is not
+// subject to deduplication.
+func (m *Master) dedupKey(name string, args interface{}, opts *TaskOptions) (string, error) {
+	if opts.IdempotencyKey != "" {
+		return idempotencyDedupKey(name, opts.IdempotencyKey), nil
+	}
+	if m.conf.DeduplicateByArgs {
+		return argsDedupKey(name, args)
+	}
+	return "", nil
+}
+
+// findDuplicate returns a reusable task with the
+// specified deduplication key (if any)
+func (m *Master) findDuplicate(dedupKey string) *Task {
+	if dedupKey == "" {
+		return nil
+	}
+	taskID, ok := m.dedupIndex[dedupKey]
+	if !ok {
+		return nil
+	}
+	task := m.tasks.Get(taskID)
+	if task == nil || !isReusable(task, m.conf.DeduplicationWindowSeconds) {
+		return nil
+	}
 	return task
 }
+
+// SendTask sends a new task to Master. Optional
+// opts may specify a queue and priority of the task
+// or a time the task should be executed at. In case
+// the task duplicates a waiting, running or recently
+// finished one (see MasterConf.DeduplicateByArgs and
+// TaskOptions.IdempotencyKey), the existing task is
+// returned instead. The returned task is a copy.
+func (m *Master) SendTask(name string, jsonArgs []byte, opts *TaskOptions) (*Task, error) {
+	log.Printf("Received task %s with args %s", name, string(jsonArgs))
+	if opts == nil {
+		opts = &TaskOptions{}
+	}
+	if opts.TaskID != "" {
+		if err := ValidateTaskID(opts.TaskID); err != nil {
+			return nil, err
+		}
+	}
+	var args interface{}
+	if len(bytes.TrimSpace(jsonArgs)) > 0 {
+		err := json.Unmarshal(jsonArgs, &args)
+		if err != nil {
+			return nil, &ValidationError{
+				Fn:         name,
+				Violations: []SchemaViolation{{Field: schemaRootField, Message: "malformed JSON: " + err.Error()}},
+			}
+		}
+	}
+	dedupKey, err := m.dedupKey(name, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	task, queue, err := m.newTask(name, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	task.DedupKey = dedupKey
+	m.mutex.Lock()
+	if m.draining {
+		m.mutex.Unlock()
+		return nil, ErrDraining
+	}
+	if existing := m.tasks.Get(task.TaskID); existing != nil {
+		m.mutex.Unlock()
+		log.Printf("INFO: task %s already submitted", existing.TaskID)
+		return existing.clone(), nil
+	}
+	if err := m.checkFunction(name); err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	if err := m.validateArgs(name, args); err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	if existing := m.findDuplicate(dedupKey); existing != nil {
+		m.mutex.Unlock()
+		log.Printf("INFO: task %s deduplicated (key %s)", existing.TaskID, dedupKey)
+		return existing.clone(), nil
+	}
+	if !opts.ETA.After(time.Now()) && queue.isFull() {
+		m.mutex.Unlock()
+		log.Printf("WARNING: rejected task %s, queue %s is full", name, queue.name)
+		return nil, &QueueFullError{
+			Queue:             queue.name,
+			MaxLength:         queue.maxLength,
+			RetryAfterSeconds: queueFullRetryAfterSeconds,
+		}
+	}
+	enqueued, err := m.submitTask(task, queue, opts.ETA)
+	if err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	if dedupKey != "" {
+		m.dedupIndex[dedupKey] = task.TaskID
+	}
+	ans := task.clone()
+	m.mutex.Unlock()
+	if enqueued {
+		m.signalQueue()
+	}
+	return ans, nil
+}
+
+// submitWorkflowTask creates and submits a task belonging
+// to a workflow. The returned value says whether the task
+// has been enqueued.
+func (m *Master) submitWorkflowTask(wf *Workflow, spec *WorkflowTaskSpec, args interface{}) (*Task, bool, error) {
+	task, queue, err := m.newTask(spec.Fn, args, &TaskOptions{Queue: spec.Queue})
+	if err != nil {
+		return nil, false, err
+	}
+	task.WorkflowID = wf.WorkflowID
+	enqueued, err := m.submitTask(task, queue, time.Time{})
+	if err != nil {
+		return nil, false, err
+	}
+	return task, enqueued, nil
+}
+
+// advanceWorkflow updates a workflow the finished task
+// belongs to and submits next tasks of the workflow if
+// needed. The returned value specifies number of enqueued
+// tasks.
+func (m *Master) advanceWorkflow(task *Task) int {
+	wf, ok := m.workflows[task.WorkflowID]
+	if !ok || wf.IsDone() {
+		return 0
+	}
+	wf.Updated = time.Now().Unix()
+	if task.TaskID == wf.CallbackTaskID || wf.Type == WorkflowChain {
+		if task.Status == taskStatusCancelled {
+			wf.cancel()
+			return 0
+		}
+		if failure := taskFailure(task); failure != "" {
+			wf.finish(nil, failure)
+			return 0
+		}
+		if wf.Type == WorkflowChord || len(wf.TaskIDs) == len(wf.spec.Tasks) {
+			wf.finish(m.taskResult(task), "")
+			return 0
+		}
+		next, enqueued, err := m.submitWorkflowTask(
+			wf, &wf.spec.Tasks[len(wf.TaskIDs)], chainArgs(wf.spec.Tasks[len(wf.TaskIDs)].Args, m.taskResult(task)))
+		if err != nil {
+			wf.finish(nil, err.Error())
+			return 0
+		}
+		wf.TaskIDs = append(wf.TaskIDs, next.TaskID)
+		if enqueued {
+			return 1
+		}
+		return 0
+	}
+
+	// group and chord - we have to wait for all the tasks
+	results := make([]interface{}, len(wf.TaskIDs))
+	failure := ""
+	for i, taskID := range wf.TaskIDs {
+		t := m.tasks.Get(taskID)
+		if t == nil {
+			failure = fmt.Sprintf("task %s not found", taskID)
+			continue
+		}
+		if !t.IsDone() {
+			return 0
+		}
+		if f := taskFailure(t); f != "" && failure == "" {
+			failure = f
+		}
+		results[i] = m.taskResult(t)
+	}
+	if failure != "" {
+		wf.finish(nil, failure)
+		return 0
+	}
+	if wf.Type == WorkflowGroup {
+		wf.finish(results, "")
+		return 0
+	}
+	callback, enqueued, err := m.submitWorkflowTask(wf, wf.spec.Callback, chainArgs(wf.spec.Callback.Args, results))
+	if err != nil {
+		wf.finish(nil, err.Error())
+		return 0
+	}
+	wf.CallbackTaskID = callback.TaskID
+	if enqueued {
+		return 1
+	}
+	return 0
+}
+
+// advanceWorkflows processes all the finished workflow
+// tasks. It is called once Master finishes handling of an
+// event as submitting new tasks while e.g. a worker result
+// is being processed would interfere with the handling.
+func (m *Master) advanceWorkflows() {
+	numEnqueued := 0
+	for len(m.doneWorkflowTasks) > 0 {
+		task := m.doneWorkflowTasks[0]
+		m.doneWorkflowTasks = m.doneWorkflowTasks[1:]
+		numEnqueued += m.advanceWorkflow(task)
+	}
+	for i := 0; i < numEnqueued; i++ {
+		m.executeNextTask()
+	}
+}
+
+// SendWorkflow creates a new workflow and submits
+// its initial tasks.
+func (m *Master) SendWorkflow(spec *WorkflowSpec) (*Workflow, error) {
+	err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+	specs := spec.Tasks
+	if spec.Callback != nil {
+		specs = append(specs[:len(specs):len(specs)], *spec.Callback)
+	}
+	for _, ts := range specs {
+		if ts.Queue != "" && m.getQueue(ts.Queue) == nil {
+			return nil, fmt.Errorf("unknown queue %s", ts.Queue)
+		}
+	}
+	workflowID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	wf := &Workflow{
+		WorkflowID: workflowID.String(),
+		Type:       spec.Type,
+		Status:     taskStatusRunning,
+		Created:    time.Now().Unix(),
+		Updated:    time.Now().Unix(),
+		TaskIDs:    make([]string, 0, len(spec.Tasks)),
+		spec:       spec,
+	}
+	initial := spec.Tasks
+	if spec.Type == WorkflowChain {
+		initial = spec.Tasks[:1]
+	}
+	numEnqueued := 0
+	m.mutex.Lock()
+	if m.draining {
+		m.mutex.Unlock()
+		return nil, ErrDraining
+	}
+	for _, ts := range specs {
+		if err := m.checkFunction(ts.Fn); err != nil {
+			m.mutex.Unlock()
+			return nil, err
+		}
+	}
+	// arguments of the other tasks are derived from
+	// results of their predecessors
+	for _, ts := range initial {
+		if err := m.validateArgs(ts.Fn, ts.Args); err != nil {
+			m.mutex.Unlock()
+			return nil, err
+		}
+	}
+	m.workflows[wf.WorkflowID] = wf
+	for i := range initial {
+		task, enqueued, err := m.submitWorkflowTask(wf, &initial[i], initial[i].Args)
+		if err != nil {
+			wf.finish(nil, err.Error())
+			break
+		}
+		wf.TaskIDs = append(wf.TaskIDs, task.TaskID)
+		if enqueued {
+			numEnqueued++
+		}
+	}
+	ans := m.getWorkflow(wf.WorkflowID)
+	m.mutex.Unlock()
+	log.Printf("INFO: created %s workflow %s", wf.Type, wf.WorkflowID)
+	for i := 0; i < numEnqueued; i++ {
+		m.signalQueue()
+	}
+	return ans, nil
+}
+
+// getWorkflow returns a snapshot of a workflow including
+// current state of its tasks
+func (m *Master) getWorkflow(workflowID string) *Workflow {
+	wf, ok := m.workflows[workflowID]
+	if !ok {
+		return nil
+	}
+	ans := *wf
+	ans.TaskIDs = append([]string{}, wf.TaskIDs...)
+	taskIDs := ans.TaskIDs
+	if wf.CallbackTaskID != "" {
+		taskIDs = append(taskIDs[:len(taskIDs):len(taskIDs)], wf.CallbackTaskID)
+	}
+	ans.Tasks = make([]*Task, 0, len(taskIDs))
+	for _, taskID := range taskIDs {
+		if task := m.tasks.Get(taskID); task != nil {
+			ans.Tasks = append(ans.Tasks, task.clone())
+		}
+	}
+	return &ans
+}
+
+// GetWorkflow returns a snapshot of a workflow
+// identified by workflowID. In case there is no
+// such workflow, nil is returned.
+func (m *Master) GetWorkflow(workflowID string) *Workflow {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getWorkflow(workflowID)
+}
@@ -0,0 +1,687 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoWorker is a worker program reporting progress
+// and finishing each task immediately
+const echoWorker = `while read line; do
+	echo '{"status": 1, "progress": {"percent": 50}}'
+	echo '{"status": 0, "result": "done"}'
+done`
+
+func TestWorkerRestartDelay(t *testing.T) {
+	w := &Worker{}
+	for crashes, delay := range []time.Duration{0, 0, 1 * time.Second, 2 * time.Second, 4 * time.Second} {
+		w.crashes = crashes
+		assert.Equal(t, delay, w.restartDelay())
+	}
+	w.crashes = 100
+	assert.Equal(t, workerRestartMaxDelay, w.restartDelay())
+}
+
+func TestWorkerStopWithoutCommandsPipe(t *testing.T) {
+	// a state left by Start in case the stdin pipe cannot be created
+	w := &Worker{
+		stopped:       make(chan bool),
+		cmd:           exec.Command("true"),
+		commandsPipe:  NewCommandPipe(),
+		responsesPipe: NewResponsePipe(1024),
+	}
+	assert.NotPanics(t, w.Stop)
+	assert.NotPanics(t, w.Stop)
+}
+
+func TestMasterWorkerFailsToStart(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1, Program: "/nonexistent/prog"}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(1500 * time.Millisecond)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	assert.Equal(t, 1, len(m.workers))
+	for worker := range m.workers {
+		assert.True(t, worker.isDown())
+		assert.True(t, worker.crashes >= 1)
+	}
+	assert.Equal(t, taskStatusWaiting, m.tasks.Get(task.TaskID).Status)
+}
+
+func TestMasterCrashingWorkerBackoff(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1, Program: "/bin/false"}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	time.Sleep(2 * time.Second)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for worker := range m.workers {
+		// 1st crash => immediate restart, then 1s, 2s,... delays
+		assert.True(t, worker.crashes >= 2 && worker.crashes <= 4)
+	}
+}
+
+// chattyWorker is a worker program sending a progress
+// update every 200 ms without ever finishing its task
+const chattyWorker = `while read line; do
+	while true; do echo '{"status": 1, "progress": {"percent": 1}}'; sleep 0.2; done
+done`
+
+func TestMasterExecLimitWithProgress(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", chattyWorker},
+		ExecMaxSeconds: 1,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(3500 * time.Millisecond)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	task = m.tasks.Get(task.TaskID)
+	assert.Equal(t, taskStatusFailed, task.Status)
+	if assert.NotNil(t, task.ErrorDetail) {
+		assert.Equal(t, errorKindTimeout, task.ErrorDetail.Kind)
+	}
+}
+
+func TestMasterGetTaskReturnsCopy(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:    1,
+		Program:     "sh",
+		ProgramArgs: []string{"-c", echoWorker},
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	taskIDs := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		task, err := m.SendTask("foo", nil, &TaskOptions{})
+		assert.Nil(t, err)
+		taskIDs = append(taskIDs, task.TaskID)
+	}
+	// encoding a task while the master updates it must
+	// not race (see go test -race)
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, taskID := range taskIDs {
+			_, err := json.Marshal(m.GetTask(taskID))
+			assert.Nil(t, err)
+		}
+	}
+	task := m.GetTask(taskIDs[0])
+	assert.Equal(t, taskStatusFinished, task.Status)
+	task.Status = taskStatusFailed
+	assert.Equal(t, taskStatusFinished, m.GetTask(taskIDs[0]).Status)
+}
+
+func TestMasterElasticPool(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		MinPoolSize:              1,
+		MaxPoolSize:              3,
+		WorkerIdleTimeoutSeconds: 1,
+		Program:                  "sh",
+		ProgramArgs:              []string{"-c", `while read line; do sleep 1; echo '{"status": 0}'; done`},
+		ExecMaxSeconds:           10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	numWorkers := func() int {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return len(m.workers)
+	}
+	assert.Equal(t, 1, numWorkers())
+	for i := 0; i < 5; i++ {
+		_, err := m.SendTask("foo", nil, &TaskOptions{})
+		assert.Nil(t, err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 3, numWorkers())
+
+	// all the tasks finish in ~2s, then the workers
+	// are idle for more than 1s
+	deadline := time.Now().Add(6 * time.Second)
+	for numWorkers() > 1 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, 1, numWorkers())
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	assert.Equal(t, 5, len(m.tasks.List()))
+	for _, task := range m.tasks.List() {
+		assert.Equal(t, taskStatusFinished, task.Status)
+	}
+}
+
+func TestNotifySubscribersDeliversDoneState(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, NewMemoryTaskStore())
+	ch := make(chan *Task, 2)
+	m.subscribers["t1"] = []chan *Task{ch}
+	task := &Task{TaskID: "t1", Status: taskStatusRunning}
+	for i := 0; i < 3; i++ {
+		m.notifySubscribers(task)
+	}
+	task.Status = taskStatusFinished
+	m.notifySubscribers(task)
+	assert.Equal(t, taskStatusRunning, (<-ch).Status)
+	assert.Equal(t, taskStatusFinished, (<-ch).Status)
+}
+
+func TestMasterTaskSentBeforeStart(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1, Program: "/nonexistent/prog"}, NewMemoryTaskStore())
+	waiting, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	_, err = m.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	m.Start()
+	defer m.Stop()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	q := m.getQueue(defaultQueueName)
+	assert.Equal(t, 1, q.size())
+	assert.True(t, q.contains(waiting.TaskID))
+	assert.Equal(t, 1, m.scheduler.size())
+}
+
+func TestMasterIgnoresStaleCrash(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `while read line; do sleep 2; echo '{"status": 0}'; done`},
+		ExecMaxSeconds: 10,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(300 * time.Millisecond)
+
+	m.mutex.Lock()
+	var worker *Worker
+	for w := range m.workers {
+		worker = w
+	}
+	pid := worker.GetPID()
+	m.mutex.Unlock()
+	// an exit of a process the worker has already replaced
+	m.workerEvent <- &WorkerStatus{
+		worker: worker, generation: worker.generation - 1, crashed: true, pid: pid + 100000, Error: "exit status 1"}
+	time.Sleep(300 * time.Millisecond)
+
+	assert.Equal(t, taskStatusRunning, m.GetTask(task.TaskID).Status)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	assert.Equal(t, pid, worker.GetPID())
+}
+
+func TestMasterRestartsUnresponsiveWorker(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:    1,
+		Program:     "sh",
+		ProgramArgs: []string{"-c", `while read line; do :; done`}, // ignores pings
+		// the worker is marked unresponsive after its first missed
+		// ping and restarted after the second one
+		HeartbeatIntervalSeconds: 1,
+		HeartbeatMaxMissed:       2,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	time.Sleep(300 * time.Millisecond)
+	pid := m.Info().WorkersInfo[0].PID
+
+	var unresponsive bool
+	for i := 0; i < 50 && !unresponsive; i++ {
+		info := m.Info().WorkersInfo[0]
+		unresponsive = info.LastStatus == "unresponsive"
+		if unresponsive {
+			assert.Equal(t, 1, info.MissedHeartbeats)
+			assert.Equal(t, pid, info.PID)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, unresponsive)
+
+	var restarted bool
+	for i := 0; i < 50 && !restarted; i++ {
+		info := m.Info().WorkersInfo[0]
+		restarted = info.PID != pid && info.PID > 0
+		if restarted {
+			assert.Equal(t, 0, info.MissedHeartbeats)
+			assert.NotEqual(t, "unresponsive", info.LastStatus)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, restarted)
+}
+
+func TestMasterKeepsRespondingWorker(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:                 1,
+		Program:                  "sh",
+		ProgramArgs:              []string{"-c", `while read line; do echo '{"control": "pong"}'; done`},
+		HeartbeatIntervalSeconds: 1,
+		HeartbeatMaxMissed:       2,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	time.Sleep(300 * time.Millisecond)
+	pid := m.Info().WorkersInfo[0].PID
+	time.Sleep(3500 * time.Millisecond)
+
+	info := m.Info().WorkersInfo[0]
+	assert.Equal(t, pid, info.PID)
+	assert.Equal(t, 0, info.MissedHeartbeats)
+	assert.NotEqual(t, "unresponsive", info.LastStatus)
+}
+
+func TestMasterRejectsInvalidTaskID(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, NewMemoryTaskStore())
+	for _, taskID := range []string{"../escaped", "a/b", "a\\b", ".."} {
+		_, err := m.SendTask("foo", nil, &TaskOptions{TaskID: taskID})
+		assert.NotNil(t, err)
+	}
+	assert.Empty(t, m.tasks.List())
+	assert.Equal(t, 0, m.getQueue(defaultQueueName).size())
+}
+
+// failingTaskStore is a TaskStore unable to store anything
+type failingTaskStore struct {
+	MemoryTaskStore
+}
+
+func (fs *failingTaskStore) Put(task *Task) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestMasterSendTaskStoreFailure(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, &failingTaskStore{*NewMemoryTaskStore()})
+	_, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.NotNil(t, err)
+	_, err = m.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, m.getQueue(defaultQueueName).size())
+	assert.Equal(t, 0, m.scheduler.size())
+}
+
+func TestMasterReleasesWorkerOfMissingTask(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `while read line; do sleep 0.5; echo '{"status": 0}'; done`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(200 * time.Millisecond)
+	m.mutex.Lock()
+	m.tasks.Delete(task.TaskID)
+	m.mutex.Unlock()
+
+	next, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, taskStatusFinished, m.GetTask(next.TaskID).Status)
+}
+
+// waitForTask waits until a task is done
+func waitForTask(t *testing.T, m *Master, taskID string) *Task {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if task := m.GetTask(taskID); task != nil && task.IsDone() {
+			return task
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("task %s not finished", taskID)
+	return nil
+}
+
+func TestMasterRecyclesWorkerAfterMaxTasks(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:          1,
+		MaxTasksPerWorker: 2,
+		Program:           "sh",
+		ProgramArgs:       []string{"-c", echoWorker},
+		ExecMaxSeconds:    10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	time.Sleep(300 * time.Millisecond)
+	pid := m.Info().WorkersInfo[0].PID
+
+	for i := 0; i < 2; i++ {
+		task, err := m.SendTask("foo", nil, &TaskOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, taskStatusFinished, waitForTask(t, m, task.TaskID).Status)
+	}
+	info := m.Info()
+	assert.Equal(t, 1, info.Recycled)
+	assert.Equal(t, 1, info.WorkersInfo[0].Recycled)
+	assert.Equal(t, 0, info.WorkersInfo[0].TasksDone)
+	assert.NotEqual(t, pid, info.WorkersInfo[0].PID)
+
+	// the fresh process executes tasks as usual
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusFinished, waitForTask(t, m, task.TaskID).Status)
+	assert.Equal(t, 1, m.Info().WorkersInfo[0].TasksDone)
+}
+
+func TestMasterRecyclesWorkerAfterMaxRSS(t *testing.T) {
+	var rss int64 = 1000
+	processRSS = func(pid int) (int64, error) {
+		return atomic.LoadInt64(&rss), nil
+	}
+	defer func() { processRSS = readProcessRSS }()
+	m := NewMaster(&MasterConf{
+		PoolSize:          1,
+		MaxWorkerRSSBytes: 2000,
+		Program:           "sh",
+		ProgramArgs:       []string{"-c", echoWorker},
+		ExecMaxSeconds:    10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	time.Sleep(300 * time.Millisecond)
+	pid := m.Info().WorkersInfo[0].PID
+
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	waitForTask(t, m, task.TaskID)
+	assert.Equal(t, 0, m.Info().Recycled)
+	assert.Equal(t, pid, m.Info().WorkersInfo[0].PID)
+
+	atomic.StoreInt64(&rss, 3000)
+	task, err = m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	waitForTask(t, m, task.TaskID)
+	info := m.Info()
+	assert.Equal(t, 1, info.Recycled)
+	assert.NotEqual(t, pid, info.WorkersInfo[0].PID)
+}
+
+func TestMasterCheckScheduledTasks(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, NewMemoryTaskStore())
+	later, err := m.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	soon, err := m.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(100 * time.Millisecond)})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusScheduled, soon.Status)
+	assert.Equal(t, 2, m.scheduler.size())
+
+	time.Sleep(1100 * time.Millisecond) // ETA is rounded up to whole seconds
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.checkScheduledTasks()
+	assert.Equal(t, taskStatusWaiting, m.tasks.Get(soon.TaskID).Status)
+	assert.True(t, m.getQueue(defaultQueueName).contains(soon.TaskID))
+	assert.Equal(t, taskStatusScheduled, m.tasks.Get(later.TaskID).Status)
+	assert.True(t, m.scheduler.contains(later.TaskID))
+	assert.Equal(t, 1, m.scheduler.size())
+}
+
+func TestMasterExecutesTaskAtETA(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", echoWorker},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	eta := time.Now().Add(1500 * time.Millisecond)
+	task, err := m.SendTask("foo", nil, &TaskOptions{ETA: eta})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusScheduled, task.Status)
+	assert.True(t, task.ETA >= eta.Unix())
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, taskStatusScheduled, m.GetTask(task.TaskID).Status)
+
+	task = waitForTask(t, m, task.TaskID)
+	assert.Equal(t, taskStatusFinished, task.Status)
+	assert.True(t, task.Started >= task.ETA)
+}
+
+func TestMasterCancelRunningTask(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `while read line; do sleep 1; echo '{"status": 0, "result": "done"}'; done`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, taskStatusRunning, m.GetTask(task.TaskID).Status)
+	pid := m.Info().WorkersInfo[0].PID
+
+	task = m.CancelTask(task.TaskID)
+	assert.Equal(t, taskStatusCancelled, task.Status)
+	assert.NotEqual(t, pid, m.Info().WorkersInfo[0].PID)
+
+	// the restarted worker is free for other tasks
+	next, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusFinished, waitForTask(t, m, next.TaskID).Status)
+	assert.Equal(t, taskStatusCancelled, m.GetTask(task.TaskID).Status)
+}
+
+func TestMasterCancelScheduledTask(t *testing.T) {
+	m := NewMaster(&MasterConf{PoolSize: 1}, NewMemoryTaskStore())
+	task, err := m.SendTask("foo", nil, &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	task = m.CancelTask(task.TaskID)
+	assert.Equal(t, taskStatusCancelled, task.Status)
+	assert.Equal(t, 0, m.scheduler.size())
+	assert.Nil(t, m.CancelTask("unknown"))
+}
+
+func TestMasterCancelFinishedTask(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", echoWorker},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	waitForTask(t, m, task.TaskID)
+	assert.Equal(t, taskStatusFinished, m.CancelTask(task.TaskID).Status)
+}
+
+func TestProcessExitCode(t *testing.T) {
+	code := processExitCode(exec.Command("sh", "-c", "exit 3").Run())
+	if assert.NotNil(t, code) {
+		assert.Equal(t, 3, *code)
+	}
+	code = processExitCode(exec.Command("sh", "-c", "kill -9 $$").Run())
+	if assert.NotNil(t, code) {
+		assert.Equal(t, -1, *code)
+	}
+	assert.Nil(t, processExitCode(exec.Command("/nonexistent/prog").Run()))
+	assert.Nil(t, processExitCode(fmt.Errorf("worker process exited")))
+}
+
+func TestMasterWorkerCrash(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		Program:  "sh",
+		ProgramArgs: []string{"-c", `read line
+			echo 'Traceback (most recent call last):' >&2
+			echo 'MemoryError' >&2
+			exit 3`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	task = waitForTask(t, m, task.TaskID)
+
+	assert.Equal(t, taskStatusFailed, task.Status)
+	assert.Equal(t, []string{"Traceback (most recent call last):", "MemoryError"}, task.Stderr)
+	if assert.NotNil(t, task.ErrorDetail) {
+		assert.Equal(t, errorKindCrash, task.ErrorDetail.Kind)
+		assert.Equal(t, "exit status 3", task.ErrorDetail.Message)
+		assert.Equal(t, task.Stderr, task.ErrorDetail.Traceback)
+		assert.True(t, task.ErrorDetail.WorkerPID > 0)
+		if assert.NotNil(t, task.ErrorDetail.ExitCode) {
+			assert.Equal(t, 3, *task.ErrorDetail.ExitCode)
+		}
+	}
+	assert.Equal(t, 1, m.Info().ErrorCounts[errorKindCrash])
+}
+
+func TestMasterApplicationError(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		Program:  "sh",
+		ProgramArgs: []string{"-c", `while read line; do
+			echo '{"status": 2, "error": "KeyError: q", "traceback": ["File \"worker.py\"", "KeyError: q"]}'
+		done`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	task = waitForTask(t, m, task.TaskID)
+
+	assert.Equal(t, taskStatusFailed, task.Status)
+	assert.Equal(t, "KeyError: q", task.Error)
+	if assert.NotNil(t, task.ErrorDetail) {
+		assert.Equal(t, errorKindApplication, task.ErrorDetail.Kind)
+		assert.Equal(t, []string{"File \"worker.py\"", "KeyError: q"}, task.ErrorDetail.Traceback)
+		assert.Nil(t, task.ErrorDetail.ExitCode)
+	}
+	assert.Equal(t, 1, m.Info().ErrorCounts[errorKindApplication])
+	assert.Equal(t, 0, m.Info().ErrorCounts[errorKindCrash])
+}
+
+func TestMasterInfoDuringProgress(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", chattyWorker},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	task, err := m.SendTask("foo", nil, &TaskOptions{})
+	assert.Nil(t, err)
+	// reading worker info while the worker reports
+	// progress must not race (see go test -race)
+	var lastStatus string
+	deadline := time.Now().Add(1 * time.Second)
+	for time.Now().Before(deadline) {
+		lastStatus = m.Info().WorkersInfo[0].LastStatus
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "running", lastStatus)
+	assert.Equal(t, taskStatusRunning, m.GetTask(task.TaskID).Status)
+}
+
+func TestMasterCancelUnderLoad(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", `while read line; do echo "{\"status\": 0, \"result\": $line}"; done`},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	taskIDs := make([]string, 0, 400)
+	for i := 0; i < 400; i++ {
+		task, err := m.SendTask("foo", []byte(fmt.Sprintf(`{"i": %d}`, i)), &TaskOptions{})
+		assert.Nil(t, err)
+		taskIDs = append(taskIDs, task.TaskID)
+	}
+	// cancel tasks while they are being executed (i.e. while
+	// their results may be on the way from the worker)
+	for i, taskID := range taskIDs {
+		for m.GetTask(taskID).Status == taskStatusWaiting {
+			time.Sleep(10 * time.Microsecond)
+		}
+		time.Sleep(time.Duration(i%4) * 50 * time.Microsecond)
+		m.CancelTask(taskID)
+	}
+	var finished int
+	for _, taskID := range taskIDs {
+		task := waitForTask(t, m, taskID)
+		if task.Status == taskStatusFinished {
+			finished++
+			// the worker echoes the call so it is possible to check
+			// a result of a cancelled task has not been attributed
+			// to the next one
+			result, ok := task.Result.(map[string]interface{})
+			if assert.True(t, ok) {
+				assert.Equal(t, taskID, result["task_id"])
+			}
+		}
+	}
+	t.Logf("%d of %d tasks finished before cancellation", finished, len(taskIDs))
+}
@@ -17,7 +17,10 @@
 package nullqueue
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/czcorpus/konserver/workpool"
 )
@@ -46,10 +49,61 @@ func (nq *NullQueue) GetTask(taskID string) *workpool.Task {
 
 // SendTask fakes creating a new task.
 // The function has no effect.
-func (nq *NullQueue) SendTask(name string, jsonArgs []byte) *workpool.Task {
+func (nq *NullQueue) SendTask(name string, jsonArgs []byte, opts *workpool.TaskOptions) (*workpool.Task, error) {
+	return nil, nil
+}
+
+// SendWorkflow fakes creating a new workflow.
+// The function has no effect.
+func (nq *NullQueue) SendWorkflow(spec *workpool.WorkflowSpec) (*workpool.Workflow, error) {
+	return nil, nil
+}
+
+// GetWorkflow returns always nil
+func (nq *NullQueue) GetWorkflow(workflowID string) *workpool.Workflow {
 	return nil
 }
 
+// ListTasks returns always an empty page
+func (nq *NullQueue) ListTasks(filter *workpool.TaskFilter) (*workpool.TaskPage, error) {
+	return &workpool.TaskPage{Tasks: []*workpool.Task{}}, nil
+}
+
+// Functions returns always an empty list
+func (nq *NullQueue) Functions() []workpool.FunctionInfo {
+	return []workpool.FunctionInfo{}
+}
+
+// OpenResultFile returns always an error
+// as there are no tasks
+func (nq *NullQueue) OpenResultFile(taskID string) (*os.File, error) {
+	return nil, fmt.Errorf("task %s has no result file", taskID)
+}
+
+// CancelTask fakes cancelling a task.
+// The function has no effect and returns nil.
+func (nq *NullQueue) CancelTask(taskID string) *workpool.Task {
+	return nil
+}
+
+// Subscribe fakes subscribing to task changes.
+// The function has no effect.
+func (nq *NullQueue) Subscribe(taskID string, ch chan *workpool.Task) {}
+
+// Unsubscribe fakes unsubscribing from task changes.
+// The function has no effect.
+func (nq *NullQueue) Unsubscribe(taskID string, ch chan *workpool.Task) {}
+
+// Drain fakes draining the queue.
+// The function has no effect and returns nil.
+func (nq *NullQueue) Drain(grace time.Duration) *workpool.Handover {
+	return nil
+}
+
+// Adopt fakes taking over pending tasks.
+// The function has no effect.
+func (nq *NullQueue) Adopt(handover *workpool.Handover) {}
+
 // Start fakes starting the service.
 // The function has no effect.
 func (nq *NullQueue) Start() {
@@ -32,22 +32,34 @@ const (
 // text commands (typically it is JSON)
 // to a worker.
 type CommandPipe struct {
-	writer *io.PipeWriter
-	reader *io.PipeReader
+	writer io.WriteCloser
 }
 
 // NewCommandPipe is the default factory method
 // for CommandPipe
 func NewCommandPipe() *CommandPipe {
-	ans := &CommandPipe{}
-	ans.reader, ans.writer = io.Pipe()
-	return ans
+	return &CommandPipe{}
 }
 
 // Register connects the pipe with
-// a provided command (Cmd)
-func (cp *CommandPipe) Register(cmd *exec.Cmd) {
-	cmd.Stdin = cp.reader
+// a provided command (Cmd). The command's
+// own stdin pipe is used (and not an in-memory one)
+// so that Cmd.Wait() does not wait for us to close
+// the pipe in case the process exits on its own.
+func (cp *CommandPipe) Register(cmd *exec.Cmd) error {
+	var err error
+	cp.writer, err = cmd.StdinPipe()
+	return err
+}
+
+// Close closes the pipe. In case the pipe has
+// not been registered (e.g. the command's stdin
+// pipe could not be created), nothing is done.
+func (cp *CommandPipe) Close() error {
+	if cp.writer == nil {
+		return nil
+	}
+	return cp.writer.Close()
 }
 
 // SendBytes sends specified bytes to the pipe
@@ -88,14 +100,19 @@ func (cp *ResponsePipe) Register(cmd *exec.Cmd) {
 	go func() {
 		sc := bufio.NewScanner(cp.reader)
 		sc.Buffer(make([]byte, initialBufferSize), cp.maxBufferSize)
+		defer close(cp.rChan)
 		for sc.Scan() {
 			cp.rChan <- sc.Text()
 		}
 		err := sc.Err()
+		if err == io.ErrClosedPipe {
+			return // the pipe has been closed by us (worker stop)
+		}
 		if err != nil {
 			log.Print("ERROR: Scanner error - ", err)
 			ans := make(map[string]string)
 			ans["error"] = err.Error()
+			ans["errorKind"] = errorKindProtocol
 			jsonAns, err := json.Marshal(ans)
 			if err != nil {
 				log.Print("ERROR: Broken Scanner error handling: ", err)
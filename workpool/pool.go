@@ -0,0 +1,193 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultPoolName = "default"
+
+	defaultWorkerIdleTimeoutSeconds = 600
+)
+
+// PoolConf describes a named pool of workers running
+// the same program. Zero values of the limits mean
+// "use the value from MasterConf".
+type PoolConf struct {
+	Name string `json:"name"`
+
+	Program string `json:"program"`
+
+	ProgramArgs []string `json:"programArgs"`
+
+	PoolSize int `json:"poolSize"`
+
+	MinPoolSize int `json:"minPoolSize"`
+
+	MaxPoolSize int `json:"maxPoolSize"`
+
+	WorkerIdleTimeoutSeconds int `json:"workerIdleTimeoutSeconds"`
+
+	MaxTasksPerWorker int `json:"maxTasksPerWorker"`
+
+	MaxWorkerRSSBytes int64 `json:"maxWorkerRSSBytes"`
+
+	ExecMaxSeconds int `json:"execMaxSeconds"`
+
+	SoftExecMaxSeconds int `json:"softExecMaxSeconds"`
+}
+
+// IsElastic tests whether the pool changes
+// its size according to the load.
+func (pc *PoolConf) IsElastic() bool {
+	return pc.MaxPoolSize > 0
+}
+
+// limits returns minimal and maximal
+// number of workers in the pool
+func (pc *PoolConf) limits() (int, int) {
+	if !pc.IsElastic() {
+		return pc.PoolSize, pc.PoolSize
+	}
+	if pc.MinPoolSize > pc.MaxPoolSize {
+		return pc.MaxPoolSize, pc.MaxPoolSize
+	}
+	return pc.MinPoolSize, pc.MaxPoolSize
+}
+
+// idleTimeout returns how long an extra worker of an elastic
+// pool can stay idle before it is stopped
+func (pc *PoolConf) idleTimeout() time.Duration {
+	if pc.WorkerIdleTimeoutSeconds <= 0 {
+		return defaultWorkerIdleTimeoutSeconds * time.Second
+	}
+	return time.Duration(pc.WorkerIdleTimeoutSeconds) * time.Second
+}
+
+// PoolRoute routes tasks to a pool either by
+// an exact function name or by its prefix
+type PoolRoute struct {
+	Fn       string `json:"fn"`
+	FnPrefix string `json:"fnPrefix"`
+	Pool     string `json:"pool"`
+}
+
+func (pr *PoolRoute) matches(fn string) bool {
+	if pr.Fn != "" {
+		return pr.Fn == fn
+	}
+	return pr.FnPrefix != "" && strings.HasPrefix(fn, pr.FnPrefix)
+}
+
+// PoolInfo provides information about a pool
+// for the "info" page
+type PoolInfo struct {
+	Name        string
+	Program     string
+	Size        int
+	MinSize     int
+	MaxSize     int
+	Recycled    int
+	WorkersInfo []WorkerInfo
+}
+
+// poolConfs returns effective configuration of all the pools.
+// In case no pools are configured, a single "default" pool
+// is created from the top-level MasterConf values.
+func (conf *MasterConf) poolConfs() []PoolConf {
+	if len(conf.Pools) == 0 {
+		return []PoolConf{
+			{
+				Name:                     defaultPoolName,
+				Program:                  conf.Program,
+				ProgramArgs:              conf.ProgramArgs,
+				PoolSize:                 conf.PoolSize,
+				MinPoolSize:              conf.MinPoolSize,
+				MaxPoolSize:              conf.MaxPoolSize,
+				WorkerIdleTimeoutSeconds: conf.WorkerIdleTimeoutSeconds,
+				MaxTasksPerWorker:        conf.MaxTasksPerWorker,
+				MaxWorkerRSSBytes:        conf.MaxWorkerRSSBytes,
+				ExecMaxSeconds:           conf.ExecMaxSeconds,
+				SoftExecMaxSeconds:       conf.SoftExecMaxSeconds,
+			},
+		}
+	}
+	ans := make([]PoolConf, len(conf.Pools))
+	for i, pc := range conf.Pools {
+		ans[i] = pc
+		if ans[i].WorkerIdleTimeoutSeconds == 0 {
+			ans[i].WorkerIdleTimeoutSeconds = conf.WorkerIdleTimeoutSeconds
+		}
+		if ans[i].MaxTasksPerWorker == 0 {
+			ans[i].MaxTasksPerWorker = conf.MaxTasksPerWorker
+		}
+		if ans[i].MaxWorkerRSSBytes == 0 {
+			ans[i].MaxWorkerRSSBytes = conf.MaxWorkerRSSBytes
+		}
+		if ans[i].ExecMaxSeconds == 0 {
+			ans[i].ExecMaxSeconds = conf.ExecMaxSeconds
+		}
+		if ans[i].SoftExecMaxSeconds == 0 {
+			ans[i].SoftExecMaxSeconds = conf.SoftExecMaxSeconds
+		}
+	}
+	return ans
+}
+
+// maxWorkers returns max. number of workers
+// of all the pools
+func (conf *MasterConf) maxWorkers() int {
+	ans := 0
+	for _, pc := range conf.poolConfs() {
+		_, maxSize := pc.limits()
+		ans += maxSize
+	}
+	return ans
+}
+
+// HasWorkers tests whether the configuration
+// specifies at least one worker pool
+func (conf *MasterConf) HasWorkers() bool {
+	return conf.maxWorkers() > 0
+}
+
+// workerPool is a runtime state of a pool. Workers
+// of all the pools are kept in Master.workers, each
+// worker refers to its pool.
+type workerPool struct {
+	conf      PoolConf
+	workerSeq int // used to generate workers' names
+	recycled  int // number of worker processes replaced due to limits
+
+	// functions contains task functions announced by the pool's
+	// workers (nil = no announcement received yet)
+	functions map[string]FunctionInfo
+}
+
+// newWorkerPools creates pools based on configuration.
+// The returned slice keeps configuration order.
+func newWorkerPools(conf *MasterConf) []*workerPool {
+	confs := conf.poolConfs()
+	ans := make([]*workerPool, len(confs))
+	for i, pc := range confs {
+		ans[i] = &workerPool{conf: pc}
+	}
+	return ans
+}
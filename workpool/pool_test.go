@@ -0,0 +1,96 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolRouteMatches(t *testing.T) {
+	byName := PoolRoute{Fn: "worker.freqs", Pool: "p"}
+	assert.True(t, byName.matches("worker.freqs"))
+	assert.False(t, byName.matches("worker.freqs2"))
+	byPrefix := PoolRoute{FnPrefix: "worker.", Pool: "p"}
+	assert.True(t, byPrefix.matches("worker.freqs"))
+	assert.False(t, byPrefix.matches("other.freqs"))
+	assert.False(t, (&PoolRoute{Pool: "p"}).matches("worker.freqs"))
+}
+
+func TestPoolConfsDefault(t *testing.T) {
+	conf := &MasterConf{Program: "python", PoolSize: 3, ExecMaxSeconds: 60}
+	pools := conf.poolConfs()
+	assert.Equal(t, 1, len(pools))
+	assert.Equal(t, defaultPoolName, pools[0].Name)
+	assert.Equal(t, "python", pools[0].Program)
+	assert.Equal(t, 3, pools[0].PoolSize)
+	assert.Equal(t, 60, pools[0].ExecMaxSeconds)
+}
+
+func TestPoolConfsInheritLimits(t *testing.T) {
+	conf := &MasterConf{
+		ExecMaxSeconds:    60,
+		MaxTasksPerWorker: 100,
+		Pools: []PoolConf{
+			{Name: "a", PoolSize: 2},
+			{Name: "b", MinPoolSize: 1, MaxPoolSize: 4, ExecMaxSeconds: 600},
+		},
+	}
+	pools := conf.poolConfs()
+	assert.Equal(t, 60, pools[0].ExecMaxSeconds)
+	assert.Equal(t, 100, pools[0].MaxTasksPerWorker)
+	assert.Equal(t, 600, pools[1].ExecMaxSeconds)
+	assert.Equal(t, 6, conf.maxWorkers())
+}
+
+func TestPoolConfIdleTimeout(t *testing.T) {
+	pc := &PoolConf{MinPoolSize: 1, MaxPoolSize: 4}
+	assert.Equal(t, defaultWorkerIdleTimeoutSeconds*time.Second, pc.idleTimeout())
+	pc.WorkerIdleTimeoutSeconds = 30
+	assert.Equal(t, 30*time.Second, pc.idleTimeout())
+}
+
+func TestMasterRoutesTasksToPoolPrograms(t *testing.T) {
+	poolWorker := func(name string) []string {
+		return []string{"-c", `while read line; do echo '{"status": 0, "result": "` + name + `"}'; done`}
+	}
+	m := NewMaster(&MasterConf{
+		ExecMaxSeconds: 10,
+		Pools: []PoolConf{
+			{Name: "a", PoolSize: 1, Program: "sh", ProgramArgs: poolWorker("a")},
+			{Name: "b", PoolSize: 1, Program: "sh", ProgramArgs: poolWorker("b")},
+		},
+		PoolRoutes: []PoolRoute{{FnPrefix: "b.", Pool: "b"}},
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	taskA, err := m.SendTask("a.foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+	taskB, err := m.SendTask("b.foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+
+	taskA = waitForTask(t, m, taskA.TaskID)
+	assert.Equal(t, "a", taskA.Pool)
+	assert.Equal(t, "a", taskA.Result)
+	taskB = waitForTask(t, m, taskB.TaskID)
+	assert.Equal(t, "b", taskB.Pool)
+	assert.Equal(t, "b", taskB.Result)
+}
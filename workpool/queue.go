@@ -0,0 +1,169 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+)
+
+const (
+	defaultQueueName = "default"
+
+	// queueFullRetryAfterSeconds is suggested to clients
+	// in case a queue is full
+	queueFullRetryAfterSeconds = 5
+)
+
+// QueueConf describes a named task queue
+type QueueConf struct {
+	Name string `json:"name"`
+
+	// Priority specifies an order in which queues
+	// are processed - a queue with higher priority
+	// is always emptied first.
+	Priority int `json:"priority"`
+
+	// FnPrefixes specifies which tasks (by their function
+	// names) are routed to the queue by default.
+	FnPrefixes []string `json:"fnPrefixes"`
+
+	// MaxLength overrides MasterConf.MaxQueueLength
+	// for the queue
+	MaxLength int `json:"maxLength"`
+}
+
+// QueueInfo provides information about
+// a queue for the "info" page
+type QueueInfo struct {
+	Name      string
+	Priority  int
+	Length    int
+	MaxLength int
+}
+
+// QueueFullError is returned when a task cannot
+// be accepted because its queue reached its max. length
+type QueueFullError struct {
+	Queue     string
+	MaxLength int
+
+	// RetryAfterSeconds is a suggested delay
+	// before the submission is repeated
+	RetryAfterSeconds int
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("queue %s is full (max. length %d)", e.Queue, e.MaxLength)
+}
+
+// taskQueue is a queue of tasks waiting for a free worker.
+// Unlike a channel, it allows removing a specific task
+// (e.g. a cancelled one) from the middle of the queue.
+// The type is not thread-safe - Master accesses it only
+// with its mutex locked.
+type taskQueue struct {
+	name      string
+	priority  int
+	maxLength int // 0 = no limit
+	items     []*Task
+}
+
+// newTaskQueue is a default factory for taskQueue
+func newTaskQueue(name string, priority int, maxLength int) *taskQueue {
+	return &taskQueue{
+		name:      name,
+		priority:  priority,
+		maxLength: maxLength,
+		items:     make([]*Task, 0, 10),
+	}
+}
+
+// push adds a task to the queue. Tasks with higher priority
+// are placed before the ones with lower priority, tasks with
+// the same priority keep FIFO order.
+func (q *taskQueue) push(task *Task) {
+	i := len(q.items)
+	for i > 0 && q.items[i-1].Priority < task.Priority {
+		i--
+	}
+	q.items = append(q.items, nil)
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = task
+}
+
+// pop removes and returns the first task
+// in the queue. In case the queue is empty,
+// nil is returned.
+func (q *taskQueue) pop() *Task {
+	if len(q.items) == 0 {
+		return nil
+	}
+	ans := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	return ans
+}
+
+// popMatching removes and returns the first task
+// for which a provided function returns true.
+// In case there is no such task, nil is returned.
+func (q *taskQueue) popMatching(match func(task *Task) bool) *Task {
+	for i, task := range q.items {
+		if match(task) {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return task
+		}
+	}
+	return nil
+}
+
+// remove removes a task identified by taskID
+// from the queue. The returned value specifies
+// whether the task has been found.
+func (q *taskQueue) remove(taskID string) bool {
+	for i, task := range q.items {
+		if task.TaskID == taskID {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// contains tests whether a task identified
+// by taskID is in the queue
+func (q *taskQueue) contains(taskID string) bool {
+	for _, task := range q.items {
+		if task.TaskID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns number of waiting tasks
+func (q *taskQueue) size() int {
+	return len(q.items)
+}
+
+// isFull tests whether the queue reached its max. length.
+// Note that push does not check the limit as tasks which
+// were already accepted (e.g. retried ones) must not
+// be rejected.
+func (q *taskQueue) isFull() bool {
+	return q.maxLength > 0 && len(q.items) >= q.maxLength
+}
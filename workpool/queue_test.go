@@ -0,0 +1,150 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskQueuePopOrder(t *testing.T) {
+	q := newTaskQueue("default", 0, 0)
+	q.push(&Task{TaskID: "a"})
+	q.push(&Task{TaskID: "b"})
+	assert.Equal(t, 2, q.size())
+	assert.Equal(t, "a", q.pop().TaskID)
+	assert.Equal(t, "b", q.pop().TaskID)
+	assert.Nil(t, q.pop())
+}
+
+func TestTaskQueuePriorityOrder(t *testing.T) {
+	q := newTaskQueue("default", 0, 0)
+	q.push(&Task{TaskID: "a", Priority: 0})
+	q.push(&Task{TaskID: "b", Priority: 5})
+	q.push(&Task{TaskID: "c", Priority: 0})
+	q.push(&Task{TaskID: "d", Priority: 5})
+	assert.Equal(t, "b", q.pop().TaskID)
+	assert.Equal(t, "d", q.pop().TaskID)
+	assert.Equal(t, "a", q.pop().TaskID)
+	assert.Equal(t, "c", q.pop().TaskID)
+}
+
+func TestTaskQueueRemove(t *testing.T) {
+	q := newTaskQueue("default", 0, 0)
+	q.push(&Task{TaskID: "a"})
+	q.push(&Task{TaskID: "b"})
+	q.push(&Task{TaskID: "c"})
+	assert.True(t, q.remove("b"))
+	assert.False(t, q.remove("x"))
+	assert.Equal(t, 2, q.size())
+	assert.Equal(t, "a", q.pop().TaskID)
+	assert.Equal(t, "c", q.pop().TaskID)
+}
+
+func TestQueueIsFull(t *testing.T) {
+	q := newTaskQueue("default", 0, 2)
+	q.push(&Task{TaskID: "a"})
+	assert.False(t, q.isFull())
+	q.push(&Task{TaskID: "b"})
+	assert.True(t, q.isFull())
+	q.pop()
+	assert.False(t, q.isFull())
+	assert.False(t, newTaskQueue("default", 0, 0).isFull())
+}
+
+func TestTaskQueuePopMatching(t *testing.T) {
+	q := newTaskQueue("default", 0, 0)
+	q.push(&Task{TaskID: "a", Pool: "p1"})
+	q.push(&Task{TaskID: "b", Pool: "p2"})
+	q.push(&Task{TaskID: "c", Pool: "p2"})
+	match := func(task *Task) bool { return task.Pool == "p2" }
+	assert.Equal(t, "b", q.popMatching(match).TaskID)
+	assert.Equal(t, "c", q.popMatching(match).TaskID)
+	assert.Nil(t, q.popMatching(match))
+	assert.Equal(t, 1, q.size())
+}
+
+func TestSendTaskQueueFull(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize:       1,
+		MaxQueueLength: 2,
+		Queues: []QueueConf{
+			{Name: "fast", Priority: 1, FnPrefixes: []string{"fast."}, MaxLength: 1},
+		},
+	}, NewMemoryTaskStore())
+	for i := 0; i < 2; i++ {
+		_, err := m.SendTask("foo", []byte(`{}`), &TaskOptions{})
+		assert.Nil(t, err)
+	}
+	_, err := m.SendTask("foo", []byte(`{}`), &TaskOptions{})
+	qErr, ok := err.(*QueueFullError)
+	assert.True(t, ok)
+	assert.Equal(t, defaultQueueName, qErr.Queue)
+	assert.Equal(t, 2, qErr.MaxLength)
+	assert.Equal(t, queueFullRetryAfterSeconds, qErr.RetryAfterSeconds)
+	assert.Equal(t, 2, m.getQueue(defaultQueueName).size())
+
+	_, err = m.SendTask("fast.foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+	_, err = m.SendTask("fast.foo", []byte(`{}`), &TaskOptions{})
+	qErr, ok = err.(*QueueFullError)
+	assert.True(t, ok)
+	assert.Equal(t, "fast", qErr.Queue)
+	assert.Equal(t, 1, qErr.MaxLength)
+
+	// scheduled tasks do not occupy the queue yet
+	_, err = m.SendTask("foo", []byte(`{}`), &TaskOptions{ETA: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+}
+
+func TestMasterRunsHigherPriorityQueueFirst(t *testing.T) {
+	// the worker returns the order in which it executed the task
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		Program:  "sh",
+		ProgramArgs: []string{"-c", `n=0; while read line; do
+			case "$line" in *'"fn":"block"'*) sleep 1;; esac
+			n=$((n+1)); echo "{\"status\": 0, \"result\": $n}"
+		done`},
+		ExecMaxSeconds: 10,
+		Queues: []QueueConf{
+			{Name: "fast", Priority: 1, FnPrefixes: []string{"fast."}},
+		},
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+	m.Start()
+	defer m.Stop()
+	block, err := m.SendTask("block", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.GetTask(block.TaskID).Status == taskStatusWaiting && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	older, err := m.SendTask("foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+	newer, err := m.SendTask("fast.foo", []byte(`{}`), &TaskOptions{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1.0, waitForTask(t, m, block.TaskID).Result)
+	assert.Equal(t, 2.0, waitForTask(t, m, newer.TaskID).Result)
+	assert.Equal(t, "fast", m.GetTask(newer.TaskID).Queue)
+	assert.Equal(t, 3.0, waitForTask(t, m, older.TaskID).Result)
+	assert.Equal(t, defaultQueueName, m.GetTask(older.TaskID).Queue)
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	defaultResultFilesSubdir = "konserver-results"
+)
+
+// moveFile moves a file. In case a simple rename is not
+// possible (e.g. the target is on a different device),
+// the file is copied and the original is removed.
+func moveFile(srcPath string, dstPath string) error {
+	if err := os.Rename(srcPath, dstPath); err == nil {
+		return nil
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		os.Remove(dstPath + ".tmp")
+		return err
+	}
+	err = dst.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(dstPath+".tmp", dstPath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(srcPath)
+}
+
+// resultFilesDir returns a directory where offloaded
+// task results are stored
+func (conf *MasterConf) resultFilesDir() string {
+	if conf.ResultFilesDir != "" {
+		return conf.ResultFilesDir
+	}
+	return filepath.Join(os.TempDir(), defaultResultFilesSubdir)
+}
+
+func (m *Master) resultFilePath(taskID string) string {
+	return filepath.Join(m.conf.resultFilesDir(), taskID+".json")
+}
+
+// storeResultFile moves a result file created by a worker
+// to the result files directory and attaches it to the task.
+func (m *Master) storeResultFile(task *Task, workerPath string) error {
+	err := os.MkdirAll(m.conf.resultFilesDir(), 0755)
+	if err != nil {
+		return err
+	}
+	path := m.resultFilePath(task.TaskID)
+	err = moveFile(workerPath, path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	task.Result = nil
+	task.ResultOffloaded = true
+	task.ResultSize = info.Size()
+	return nil
+}
+
+// taskResult returns a result of a finished task as passed
+// to other tasks (e.g. within a workflow). In case the result
+// is offloaded, an object with "resultFile" path is returned.
+func (m *Master) taskResult(task *Task) interface{} {
+	if task.ResultOffloaded {
+		return map[string]interface{}{"resultFile": m.resultFilePath(task.TaskID)}
+	}
+	return task.Result
+}
+
+// removeResultFile deletes an offloaded result of a task
+func (m *Master) removeResultFile(task *Task) {
+	if !task.ResultOffloaded {
+		return
+	}
+	err := os.Remove(m.resultFilePath(task.TaskID))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("ERROR: failed to remove result file of task %s: %s", task.TaskID, err)
+	}
+}
+
+// OpenResultFile opens an offloaded result of a task.
+// The file contains JSON-encoded result as written by
+// the worker. The caller is responsible for closing the file.
+func (m *Master) OpenResultFile(taskID string) (*os.File, error) {
+	task := m.GetTask(taskID)
+	if task == nil || !task.ResultOffloaded {
+		return nil, fmt.Errorf("task %s has no result file", taskID)
+	}
+	return os.Open(m.resultFilePath(taskID))
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.json")
+	dst := filepath.Join(dir, "dst.json")
+	assert.Nil(t, ioutil.WriteFile(src, []byte(`[1, 2]`), 0644))
+	assert.Nil(t, moveFile(src, dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, `[1, 2]`, string(data))
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMoveFileAcrossDevices(t *testing.T) {
+	// a tmpfs is typically a different device than the temp dir
+	otherDir, err := ioutil.TempDir("/dev/shm", "konserver-results")
+	if err != nil {
+		t.Skip("no /dev/shm available: ", err)
+	}
+	defer os.RemoveAll(otherDir)
+	dir, err := ioutil.TempDir("", "konserver-results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.json")
+	dst := filepath.Join(otherDir, "dst.json")
+	assert.Nil(t, ioutil.WriteFile(src, []byte(`{"a": 1}`), 0644))
+	assert.Nil(t, moveFile(src, dst))
+	data, err := ioutil.ReadFile(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a": 1}`, string(data))
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dst + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStoreResultFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := NewMaster(&MasterConf{ResultFilesDir: filepath.Join(dir, "results")}, NewMemoryTaskStore())
+	workerPath := filepath.Join(dir, "worker-output.json")
+	assert.Nil(t, ioutil.WriteFile(workerPath, []byte(`{"freqs": [1, 2, 3]}`), 0644))
+	task := &Task{TaskID: "t1", Status: taskStatusRunning, Result: "placeholder"}
+	assert.Nil(t, m.storeResultFile(task, workerPath))
+	assert.Nil(t, task.Result)
+	assert.True(t, task.ResultOffloaded)
+	assert.Equal(t, int64(20), task.ResultSize)
+	assert.Equal(t, map[string]interface{}{"resultFile": filepath.Join(dir, "results", "t1.json")},
+		m.taskResult(task))
+
+	task.Status = taskStatusFinished
+	m.tasks.Put(task)
+	file, err := m.OpenResultFile("t1")
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(file)
+	file.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"freqs": [1, 2, 3]}`, string(data))
+}
+
+func TestExpiredTaskResultFileRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-results")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := NewMaster(&MasterConf{ResultFilesDir: dir, TaskResultPersistMaxSeconds: 60}, NewMemoryTaskStore())
+	workerPath := filepath.Join(dir, "worker-output.json")
+	assert.Nil(t, ioutil.WriteFile(workerPath, []byte(`[]`), 0644))
+	task := &Task{TaskID: "t1", Status: taskStatusFinished}
+	assert.Nil(t, m.storeResultFile(task, workerPath))
+	task.Updated = time.Now().Unix() - 120
+	m.tasks.Put(task)
+
+	m.checkForOldTasks()
+	assert.Nil(t, m.tasks.Get("t1"))
+	_, err = os.Stat(m.resultFilePath("t1"))
+	assert.True(t, os.IsNotExist(err))
+}
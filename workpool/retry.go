@@ -0,0 +1,81 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	defaultBackoffMultiplier = 2.0
+)
+
+// RetryPolicy specifies how a failed task
+// of a specific function is retried.
+type RetryPolicy struct {
+
+	// MaxAttempts specifies how many times the task
+	// can be executed in total (i.e. including the first
+	// attempt)
+	MaxAttempts int `json:"maxAttempts"`
+
+	// BackoffSeconds specifies a delay before the
+	// first retry
+	BackoffSeconds float64 `json:"backoffSeconds"`
+
+	// BackoffMultiplier specifies how the delay grows
+	// with each next retry (default is 2)
+	BackoffMultiplier float64 `json:"backoffMultiplier"`
+
+	// MaxBackoffSeconds limits the delay (0 = no limit)
+	MaxBackoffSeconds float64 `json:"maxBackoffSeconds"`
+
+	// RetryOn lists error kinds (see TaskError.Kind) which
+	// make the task to be retried. If empty, crashes and
+	// timeouts are retried.
+	RetryOn []string `json:"retryOn"`
+}
+
+// isRetryable tests whether an error of a specified
+// kind can be retried.
+func (rp *RetryPolicy) isRetryable(errorKind string) bool {
+	if len(rp.RetryOn) == 0 {
+		return errorKind == errorKindCrash || errorKind == errorKindTimeout
+	}
+	for _, kind := range rp.RetryOn {
+		if kind == errorKind {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a delay before next attempt
+// of a task which has been already executed
+// 'attempt' times.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := rp.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+	secs := rp.BackoffSeconds * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoffSeconds > 0 && secs > rp.MaxBackoffSeconds {
+		secs = rp.MaxBackoffSeconds
+	}
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	rp := &RetryPolicy{BackoffSeconds: 1, MaxBackoffSeconds: 5}
+	assert.Equal(t, 1*time.Second, rp.backoff(1))
+	assert.Equal(t, 2*time.Second, rp.backoff(2))
+	assert.Equal(t, 4*time.Second, rp.backoff(3))
+	assert.Equal(t, 5*time.Second, rp.backoff(4))
+}
+
+func TestRetryPolicyDefaultRetryOn(t *testing.T) {
+	rp := &RetryPolicy{}
+	assert.True(t, rp.isRetryable(errorKindCrash))
+	assert.True(t, rp.isRetryable(errorKindTimeout))
+	assert.False(t, rp.isRetryable(errorKindApplication))
+}
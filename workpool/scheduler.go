@@ -0,0 +1,112 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"container/heap"
+)
+
+// taskHeap implements heap.Interface with
+// tasks ordered by their ETA
+type taskHeap []*Task
+
+func (h taskHeap) Len() int {
+	return len(h)
+}
+
+func (h taskHeap) Less(i, j int) bool {
+	return h[i].ETA < h[j].ETA
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Task))
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	ans := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return ans
+}
+
+// ---------------------------------------------------------------
+
+// taskScheduler keeps tasks which are not supposed
+// to be executed before their ETA. The type is not
+// thread-safe - Master accesses it only with its
+// mutex locked.
+type taskScheduler struct {
+	tasks taskHeap
+}
+
+// newTaskScheduler is a default factory for taskScheduler
+func newTaskScheduler() *taskScheduler {
+	return &taskScheduler{
+		tasks: make(taskHeap, 0, 10),
+	}
+}
+
+// push adds a task to the scheduler. The task's ETA
+// must be already set.
+func (s *taskScheduler) push(task *Task) {
+	heap.Push(&s.tasks, task)
+}
+
+// popDue removes and returns all the tasks
+// with ETA less or equal to 'now' (unix time)
+func (s *taskScheduler) popDue(now int64) []*Task {
+	ans := make([]*Task, 0, 5)
+	for len(s.tasks) > 0 && s.tasks[0].ETA <= now {
+		ans = append(ans, heap.Pop(&s.tasks).(*Task))
+	}
+	return ans
+}
+
+// remove removes a task identified by taskID
+// from the scheduler. The returned value specifies
+// whether the task has been found.
+func (s *taskScheduler) remove(taskID string) bool {
+	for i, task := range s.tasks {
+		if task.TaskID == taskID {
+			heap.Remove(&s.tasks, i)
+			return true
+		}
+	}
+	return false
+}
+
+// contains tests whether a task identified
+// by taskID is in the scheduler
+func (s *taskScheduler) contains(taskID string) bool {
+	for _, task := range s.tasks {
+		if task.TaskID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+// size returns number of scheduled tasks
+func (s *taskScheduler) size() int {
+	return len(s.tasks)
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskSchedulerPopDueOrder(t *testing.T) {
+	s := newTaskScheduler()
+	for i, eta := range []int64{50, 10, 30, 20, 40} {
+		s.push(&Task{TaskID: string(rune('a' + i)), ETA: eta})
+	}
+	assert.Empty(t, s.popDue(5))
+	due := s.popDue(30)
+	assert.Equal(t, 3, len(due))
+	assert.Equal(t, "b", due[0].TaskID)
+	assert.Equal(t, "d", due[1].TaskID)
+	assert.Equal(t, "c", due[2].TaskID)
+	assert.Equal(t, 2, s.size())
+}
+
+func TestTaskSchedulerRemove(t *testing.T) {
+	s := newTaskScheduler()
+	s.push(&Task{TaskID: "a", ETA: 20})
+	s.push(&Task{TaskID: "b", ETA: 10})
+	s.push(&Task{TaskID: "c", ETA: 30})
+	assert.True(t, s.remove("b"))
+	assert.False(t, s.remove("b"))
+	assert.False(t, s.contains("b"))
+	assert.True(t, s.contains("a"))
+	due := s.popDue(100)
+	assert.Equal(t, 2, len(due))
+	assert.Equal(t, "a", due[0].TaskID)
+	assert.Equal(t, "c", due[1].TaskID)
+}
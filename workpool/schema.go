@@ -0,0 +1,296 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	schemaRootField = "args"
+)
+
+// schemaTypes contains JSON schema type names
+// (the "type" keyword) supported by jsonSchema
+var schemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// schemaType is a value of the "type" keyword which
+// can be either a single type name or a list of names
+type schemaType []string
+
+func (st *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*st = schemaType{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*st = schemaType(multi)
+	return nil
+}
+
+// additionalProperties is a value of the "additionalProperties"
+// keyword which can be either a boolean or a schema applied
+// to all the properties not listed in "properties"
+type additionalProperties struct {
+	allowed bool
+	schema  *jsonSchema
+}
+
+func (ap *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &ap.allowed); err == nil {
+		return nil
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return fmt.Errorf("additionalProperties must be a boolean or a schema")
+	}
+	ap.allowed = true
+	ap.schema = &schema
+	return nil
+}
+
+// jsonSchema is a subset of JSON Schema used to validate
+// task arguments. Supported keywords are: type, enum,
+// required, properties, additionalProperties, items, minimum, maximum, minLength, maxLength, minItems,
+// maxItems and pattern. Other keywords are ignored.
+type jsonSchema struct {
+	Type                 schemaType             `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Pattern              string                 `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaViolation describes a single argument
+// which does not conform to a function's schema
+type SchemaViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned in case task arguments
+// are malformed or do not conform to a function's schema
+type ValidationError struct {
+	Fn         string            `json:"fn"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	items := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		items[i] = fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	return fmt.Sprintf("invalid arguments of %s (%s)", e.Fn, strings.Join(items, "; "))
+}
+
+// parseSchema parses and checks a JSON-encoded schema
+func parseSchema(data json.RawMessage) (*jsonSchema, error) {
+	var ans jsonSchema
+	if err := json.Unmarshal(data, &ans); err != nil {
+		return nil, err
+	}
+	if err := ans.compile(); err != nil {
+		return nil, err
+	}
+	return &ans, nil
+}
+
+// compile checks type names and compiles
+// patterns of the schema and all its subschemas
+func (s *jsonSchema) compile() error {
+	for _, t := range s.Type {
+		if !schemaTypes[t] {
+			return fmt.Errorf("unsupported type %s", t)
+		}
+	}
+	if s.Pattern != "" {
+		var err error
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+	for name, prop := range s.Properties {
+		if prop == nil {
+			return fmt.Errorf("property %s has no schema", name)
+		}
+		if err := prop.compile(); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil {
+		if err := s.AdditionalProperties.schema.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// jsonTypeOf returns JSON schema type name of a value
+// decoded by encoding/json into interface{}
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+func (s *jsonSchema) matchesType(value interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	valueType := jsonTypeOf(value)
+	for _, t := range s.Type {
+		if t == valueType || t == "number" && valueType == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns all the violations found in value
+func (s *jsonSchema) validate(value interface{}) []SchemaViolation {
+	ans := make([]SchemaViolation, 0, 5)
+	s.validateField(value, schemaRootField, &ans)
+	return ans
+}
+
+func (s *jsonSchema) validateField(value interface{}, field string, ans *[]SchemaViolation) {
+	addViolation := func(msg string, args ...interface{}) {
+		*ans = append(*ans, SchemaViolation{Field: field, Message: fmt.Sprintf(msg, args...)})
+	}
+	if !s.matchesType(value) {
+		addViolation("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeOf(value))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, item := range s.Enum {
+			if reflect.DeepEqual(item, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			addViolation("value is not one of the allowed values")
+		}
+	}
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			addViolation("value must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			addViolation("value must be <= %v", *s.Maximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			addViolation("length must be >= %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			addViolation("length must be <= %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			addViolation("value does not match pattern %s", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			addViolation("number of items must be >= %d", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			addViolation("number of items must be <= %d", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validateField(item, fmt.Sprintf("%s[%d]", field, i), ans)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*ans = append(*ans, SchemaViolation{Field: field + "." + name, Message: "missing required field"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				prop.validateField(v[name], field+"."+name, ans)
+
+			} else if s.AdditionalProperties == nil {
+				continue
+
+			} else if s.AdditionalProperties.schema != nil {
+				s.AdditionalProperties.schema.validateField(v[name], field+"."+name, ans)
+
+			} else if !s.AdditionalProperties.allowed {
+				*ans = append(*ans, SchemaViolation{Field: field + "." + name, Message: "unknown field"})
+			}
+		}
+	}
+}
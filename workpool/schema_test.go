@@ -0,0 +1,133 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParseSchema(t *testing.T, data string) *jsonSchema {
+	schema, err := parseSchema(json.RawMessage(data))
+	assert.Nil(t, err)
+	return schema
+}
+
+func decodeArgs(data string) interface{} {
+	var ans interface{}
+	json.Unmarshal([]byte(data), &ans)
+	return ans
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	_, err := parseSchema(json.RawMessage(`{"type": "int"}`))
+	assert.Error(t, err)
+	_, err = parseSchema(json.RawMessage(`{"properties": {"a": {"pattern": "("}}}`))
+	assert.Error(t, err)
+	_, err = parseSchema(json.RawMessage(`{"properties": {"a": null}}`))
+	assert.Error(t, err)
+	_, err = parseSchema(json.RawMessage(`{"items": {"properties": {"a": null}}}`))
+	assert.Error(t, err)
+	_, err = parseSchema(json.RawMessage(`{"additionalProperties": "no"}`))
+	assert.Error(t, err)
+	_, err = parseSchema(json.RawMessage(`{"additionalProperties": {"type": "int"}}`))
+	assert.Error(t, err)
+}
+
+func TestSchemaValidateObject(t *testing.T) {
+	schema := mustParseSchema(t, `{
+		"type": "object",
+		"required": ["word", "a"],
+		"additionalProperties": false,
+		"properties": {
+			"word": {"type": "string", "minLength": 1},
+			"a": {"type": "integer", "minimum": 0},
+			"mode": {"enum": ["fast", "slow"]}
+		}
+	}`)
+	assert.Empty(t, schema.validate(decodeArgs(`{"word": "x", "a": 2, "mode": "fast"}`)))
+	assert.Equal(t, []SchemaViolation{
+		{Field: "args.a", Message: "missing required field"},
+		{Field: "args.foo", Message: "unknown field"},
+		{Field: "args.mode", Message: "value is not one of the allowed values"},
+		{Field: "args.word", Message: "length must be >= 1"},
+	}, schema.validate(decodeArgs(`{"word": "", "mode": "x", "foo": 1}`)))
+	assert.Equal(t, []SchemaViolation{
+		{Field: "args.a", Message: "expected integer, got number"},
+	}, schema.validate(decodeArgs(`{"word": "x", "a": 1.5}`)))
+	assert.Equal(t, []SchemaViolation{
+		{Field: "args", Message: "expected object, got null"},
+	}, schema.validate(nil))
+}
+
+func TestSchemaValidateAdditionalPropertiesSchema(t *testing.T) {
+	schema := mustParseSchema(t, `{
+		"type": "object",
+		"properties": {"word": {"type": "string"}},
+		"additionalProperties": {"type": "integer", "minimum": 0}
+	}`)
+	assert.Empty(t, schema.validate(decodeArgs(`{"word": "x", "a": 1, "b": 2}`)))
+	assert.Equal(t, []SchemaViolation{
+		{Field: "args.a", Message: "expected integer, got string"},
+		{Field: "args.b", Message: "value must be >= 0"},
+	}, schema.validate(decodeArgs(`{"word": "x", "a": "1", "b": -1}`)))
+
+	schema = mustParseSchema(t, `{"additionalProperties": true}`)
+	assert.Empty(t, schema.validate(decodeArgs(`{"a": 1}`)))
+}
+
+func TestSchemaValidateArray(t *testing.T) {
+	schema := mustParseSchema(t, `{
+		"type": ["array", "null"],
+		"maxItems": 2,
+		"items": {"type": "string", "pattern": "^[a-z]+$"}
+	}`)
+	assert.Empty(t, schema.validate(nil))
+	assert.Empty(t, schema.validate(decodeArgs(`["ab", "c"]`)))
+	assert.Equal(t, []SchemaViolation{
+		{Field: "args", Message: "number of items must be <= 2"},
+		{Field: "args[1]", Message: "value does not match pattern ^[a-z]+$"},
+		{Field: "args[2]", Message: "expected string, got integer"},
+	}, schema.validate(decodeArgs(`["a", "B", 3]`)))
+}
+
+func TestSendTaskValidatesArgs(t *testing.T) {
+	m := NewMaster(&MasterConf{
+		PoolSize: 1,
+		ArgsSchemas: map[string]json.RawMessage{
+			"foo": json.RawMessage(`{"type": "object", "required": ["a"], "properties": {"a": {"type": "integer"}}}`),
+		},
+	}, NewMemoryTaskStore())
+	_, err := m.SendTask("foo", []byte(`{"a": "x"}`), &TaskOptions{})
+	assert.Equal(t, &ValidationError{
+		Fn:         "foo",
+		Violations: []SchemaViolation{{Field: "args.a", Message: "expected integer, got string"}},
+	}, err)
+	_, err = m.SendTask("foo", []byte(`{"a":`), &TaskOptions{})
+	vErr, ok := err.(*ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, schemaRootField, vErr.Violations[0].Field)
+	assert.Equal(t, 0, m.getQueue(defaultQueueName).size())
+
+	_, err = m.SendTask("foo", []byte(`{"a": 1}`), &TaskOptions{})
+	assert.Nil(t, err)
+	// functions without a schema accept anything
+	_, err = m.SendTask("bar", []byte(`{"a": "x"}`), &TaskOptions{})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	defaultStderrBufferLines = 100
+
+	// maxStderrLineLen limits length of a single kept stderr line;
+	// the rest of a longer line is read and thrown away
+	maxStderrLineLen = 4096
+)
+
+// stderrBuffer is a ring buffer keeping last lines
+// written by a worker process to its standard error output.
+// Lines are numbered (starting from zero) so it is possible
+// to obtain lines written since a specific moment.
+type stderrBuffer struct {
+	lines []string
+	total int // number of lines written so far
+	mutex *sync.Mutex
+}
+
+// newStderrBuffer is a default factory for stderrBuffer
+func newStderrBuffer(capacity int) *stderrBuffer {
+	if capacity <= 0 {
+		capacity = defaultStderrBufferLines
+	}
+	return &stderrBuffer{
+		lines: make([]string, capacity),
+		mutex: &sync.Mutex{},
+	}
+}
+
+func (sb *stderrBuffer) add(line string) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.lines[sb.total%len(sb.lines)] = line
+	sb.total++
+}
+
+// mark returns a number of the next line to be written
+func (sb *stderrBuffer) mark() int {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	return sb.total
+}
+
+// since returns lines written since mark. In case some
+// of the lines are not available anymore, only the kept
+// ones are returned.
+func (sb *stderrBuffer) since(mark int) []string {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	if mark < sb.total-len(sb.lines) {
+		mark = sb.total - len(sb.lines)
+	}
+	if mark < 0 {
+		mark = 0
+	}
+	ans := make([]string, 0, sb.total-mark)
+	for i := mark; i < sb.total; i++ {
+		ans = append(ans, sb.lines[i%len(sb.lines)])
+	}
+	return ans
+}
+
+// tail returns last n lines
+func (sb *stderrBuffer) tail(n int) []string {
+	return sb.since(sb.mark() - n)
+}
+
+// consume reads lines from src until EOF, logs them
+// with the worker's PID and keeps them in the buffer.
+// Lines longer than maxStderrLineLen are truncated but
+// the stream is always read to its end so the worker never
+// blocks on writing to its stderr.
+func (sb *stderrBuffer) consume(src io.Reader, pid int) {
+	reader := bufio.NewReader(src)
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			if len(line) > 0 {
+				sb.addLogged(string(line), pid)
+			}
+			if err != io.EOF && err != io.ErrClosedPipe {
+				log.Printf("WARNING: failed to read stderr of worker %d: %s", pid, err)
+			}
+			return
+		}
+		if rest := maxStderrLineLen - len(line); rest > 0 {
+			if len(chunk) > rest {
+				chunk = chunk[:rest]
+			}
+			line = append(line, chunk...)
+		}
+		if !isPrefix {
+			sb.addLogged(string(line), pid)
+			line = line[:0]
+		}
+	}
+}
+
+func (sb *stderrBuffer) addLogged(line string, pid int) {
+	log.Printf("WORKER[%d] STDERR: %s", pid, line)
+	sb.add(line)
+}
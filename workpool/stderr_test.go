@@ -0,0 +1,79 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStderrBufferSince(t *testing.T) {
+	sb := newStderrBuffer(5)
+	sb.add("a")
+	mark := sb.mark()
+	sb.add("b")
+	sb.add("c")
+	assert.Equal(t, []string{"b", "c"}, sb.since(mark))
+	assert.Equal(t, []string{}, sb.since(sb.mark()))
+}
+
+func TestStderrBufferOverflow(t *testing.T) {
+	sb := newStderrBuffer(3)
+	mark := sb.mark()
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		sb.add(s)
+	}
+	assert.Equal(t, []string{"c", "d", "e"}, sb.since(mark))
+	assert.Equal(t, []string{"d", "e"}, sb.tail(2))
+	assert.Equal(t, []string{"c", "d", "e"}, sb.tail(10))
+}
+
+func TestStderrBufferConsume(t *testing.T) {
+	sb := newStderrBuffer(10)
+	sb.consume(strings.NewReader("Traceback (most recent call last):\n  File \"worker.py\"\nKeyError: 'q'\n"), 1234)
+	assert.Equal(t, []string{"  File \"worker.py\"", "KeyError: 'q'"}, sb.tail(2))
+}
+
+func TestStderrBufferConsumeLongLine(t *testing.T) {
+	sb := newStderrBuffer(10)
+	rd, wr := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		sb.consume(rd, 1234)
+		close(done)
+	}()
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(wr, strings.Repeat("x", 100*1024)+"\nnext line\nlast line\n")
+		written <- err
+		wr.Close()
+	}()
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing to stderr blocked after a long line")
+	}
+	<-done
+	tail := sb.tail(3)
+	assert.Equal(t, strings.Repeat("x", maxStderrLineLen), tail[0])
+	assert.Equal(t, []string{"next line", "last line"}, tail[1:])
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	taskFileSuffix = ".json"
+
+	// taskProgressPersistInterval specifies how often a task
+	// is written by FileTaskStore in case its status does not
+	// change (i.e. typically just its progress is updated)
+	taskProgressPersistInterval = 5 * time.Second
+)
+
+// TaskStore keeps all the tasks Master knows about.
+// Master works with references to stored tasks and
+// modifies them in place so Put must be called each time
+// a task changes to make sure the change is persisted.
+type TaskStore interface {
+	Get(taskID string) *Task
+	Put(task *Task) error
+	Delete(taskID string) error
+	List() []*Task
+}
+
+// NewTaskStore creates a task store based on
+// provided configuration. In case no store
+// directory is configured, tasks are kept
+// in memory only.
+func NewTaskStore(conf *MasterConf) (TaskStore, error) {
+	if conf.TaskStoreDir != "" {
+		return NewFileTaskStore(conf.TaskStoreDir)
+	}
+	return NewMemoryTaskStore(), nil
+}
+
+// ---------------------------------------------------------------
+
+// MemoryTaskStore is a TaskStore keeping tasks
+// in memory. Tasks do not survive konserver
+// restart.
+type MemoryTaskStore struct {
+	tasks map[string]*Task
+	mutex *sync.Mutex
+}
+
+// NewMemoryTaskStore is a default factory for MemoryTaskStore
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		tasks: make(map[string]*Task),
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Get returns a task identified by taskID.
+// In case there is no such task, nil is returned.
+func (ms *MemoryTaskStore) Get(taskID string) *Task {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.tasks[taskID]
+}
+
+// Put adds or updates a task
+func (ms *MemoryTaskStore) Put(task *Task) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.tasks[task.TaskID] = task
+	return nil
+}
+
+// Delete removes a task identified by taskID
+func (ms *MemoryTaskStore) Delete(taskID string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.tasks, taskID)
+	return nil
+}
+
+// List returns all the stored tasks
+func (ms *MemoryTaskStore) List() []*Task {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ans := make([]*Task, 0, len(ms.tasks))
+	for _, task := range ms.tasks {
+		ans = append(ans, task)
+	}
+	return ans
+}
+
+// ---------------------------------------------------------------
+
+// FileTaskStore is a TaskStore which writes each task
+// as a JSON file into a configured directory. All the
+// tasks are also kept in memory so reading is cheap.
+// To avoid writing a file on each progress update, a task
+// whose status has not changed is written at most once
+// per taskProgressPersistInterval.
+type FileTaskStore struct {
+	MemoryTaskStore
+	dirPath          string
+	persisted        map[string]persistedTask
+	progressInterval time.Duration
+}
+
+// persistedTask describes the last written
+// version of a task file
+type persistedTask struct {
+	status  int
+	written time.Time
+}
+
+// NewFileTaskStore creates a FileTaskStore working within
+// dirPath. The directory is created if it does not exist
+// and all the tasks already stored there are loaded.
+func NewFileTaskStore(dirPath string) (*FileTaskStore, error) {
+	err := os.MkdirAll(dirPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+	ans := &FileTaskStore{
+		MemoryTaskStore:  *NewMemoryTaskStore(),
+		dirPath:          dirPath,
+		persisted:        make(map[string]persistedTask),
+		progressInterval: taskProgressPersistInterval,
+	}
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), taskFileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dirPath, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var task Task
+		err = json.Unmarshal(data, &task)
+		if err != nil {
+			log.Printf("ERROR: skipping broken task file %s: %s", file.Name(), err)
+			continue
+		}
+		ans.tasks[task.TaskID] = &task
+		ans.persisted[task.TaskID] = persistedTask{status: task.Status, written: file.ModTime()}
+	}
+	log.Printf("INFO: loaded %d task(s) from %s", len(ans.tasks), dirPath)
+	return ans, nil
+}
+
+func (fs *FileTaskStore) taskPath(taskID string) (string, error) {
+	if err := ValidateTaskID(taskID); err != nil {
+		return "", err
+	}
+	return filepath.Join(fs.dirPath, taskID+taskFileSuffix), nil
+}
+
+// Put adds or updates a task. The task is written
+// to a temporary file first and then renamed so
+// a crash cannot leave a half-written file behind.
+// In case the task's status is the same as the written
+// one and the file has been written recently, the task
+// is updated just in memory.
+func (fs *FileTaskStore) Put(task *Task) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	path, err := fs.taskPath(task.TaskID)
+	if err != nil {
+		return err
+	}
+	if last, ok := fs.persisted[task.TaskID]; ok && last.status == task.Status &&
+		time.Since(last.written) < fs.progressInterval {
+		fs.tasks[task.TaskID] = task
+		return nil
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return err
+	}
+	fs.tasks[task.TaskID] = task
+	fs.persisted[task.TaskID] = persistedTask{status: task.Status, written: time.Now()}
+	return nil
+}
+
+// Delete removes a task identified by taskID
+func (fs *FileTaskStore) Delete(taskID string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	delete(fs.tasks, taskID)
+	delete(fs.persisted, taskID)
+	path, err := fs.taskPath(taskID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
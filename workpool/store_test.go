@@ -0,0 +1,90 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTaskStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-tasks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileTaskStore(dir)
+	assert.Nil(t, err)
+	store.Put(&Task{TaskID: "t1", Fn: "foo", Status: taskStatusWaiting})
+	store.Put(&Task{TaskID: "t2", Fn: "bar", Status: taskStatusFinished, Result: "ok"})
+	store.Delete("t1")
+
+	store2, err := NewFileTaskStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, store2.Get("t1"))
+	task := store2.Get("t2")
+	assert.NotNil(t, task)
+	assert.Equal(t, "bar", task.Fn)
+	assert.Equal(t, "ok", task.Result)
+	assert.Equal(t, 1, len(store2.List()))
+}
+
+func TestFileTaskStoreRejectsPathLikeID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-tasks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileTaskStore(dir)
+	assert.Nil(t, err)
+	assert.NotNil(t, store.Put(&Task{TaskID: "../foo"}))
+}
+
+func TestFileTaskStoreThrottlesProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "konserver-tasks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileTaskStore(dir)
+	assert.Nil(t, err)
+	readTask := func() *Task {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "t1"+taskFileSuffix))
+		assert.Nil(t, err)
+		var task Task
+		assert.Nil(t, json.Unmarshal(data, &task))
+		return &task
+	}
+	task := &Task{TaskID: "t1", Fn: "foo", Status: taskStatusRunning}
+	assert.Nil(t, store.Put(task))
+	task.Progress = &TaskProgress{Percent: 50}
+	assert.Nil(t, store.Put(task))
+	assert.Equal(t, task, store.Get("t1"))
+	assert.Nil(t, readTask().Progress)
+
+	task.Status = taskStatusFinished
+	assert.Nil(t, store.Put(task))
+	assert.Equal(t, taskStatusFinished, readTask().Status)
+	assert.Equal(t, 50.0, readTask().Progress.Percent)
+
+	store.progressInterval = 0
+	task.Progress = &TaskProgress{Percent: 100}
+	assert.Nil(t, store.Put(task))
+	assert.Equal(t, 100.0, readTask().Progress.Percent)
+}
@@ -18,6 +18,7 @@ package workpool
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -32,21 +33,133 @@ const (
 	// taskStatusFinished means execution finished
 	// without error
 	taskStatusFinished = 2
+
+	// taskStatusCancelled means the task has been
+	// cancelled by a client before it finished
+	taskStatusCancelled = 3
+
+	// taskStatusScheduled means the task waits
+	// for its ETA before it is enqueued
+	taskStatusScheduled = 4
+
+	// taskStatusFailed means the task ended with an error
+	// (see Task.ErrorDetail) and no other attempt follows
+	taskStatusFailed = 5
 )
 
+// TaskOptions contains optional parameters
+// of a newly submitted task.
+type TaskOptions struct {
+
+	// Queue specifies a queue the task will be put into.
+	// If empty, the queue is chosen by configured routing.
+	Queue string
+
+	// Priority specifies an order of the task within
+	// its queue. If nil, the priority of the queue is used.
+	Priority *int
+
+	// ETA specifies the earliest time the task can be
+	// executed at. Zero value means "as soon as possible".
+	ETA time.Time
+
+	// IdempotencyKey identifies a submission. A repeated
+	// submission with the same key (and function) returns
+	// the original task instead of creating a new one.
+	IdempotencyKey string
+
+	// Submitter identifies a client which submitted the task
+	// (e.g. a service name). It is used only for task listing.
+	Submitter string
+
+	// TaskID specifies an ID of the new task (e.g. an ID
+	// generated by a Celery client). If empty, a new UUID
+	// is generated. A repeated submission with the same ID
+	// returns the existing task. See ValidateTaskID for
+	// allowed values.
+	TaskID string
+}
+
+// TaskProgress describes a progress of a running
+// task as reported by its worker.
+type TaskProgress struct {
+
+	// Percent is an estimated completion (0 - 100)
+	Percent float64 `json:"percent"`
+
+	Message string `json:"message"`
+
+	// Counts contains arbitrary partial results
+	// (e.g. number of processed concordance lines)
+	Counts map[string]int `json:"counts,omitempty"`
+}
+
+// ValidateTaskID checks whether taskID can be used as an ID
+// of a task. Task IDs become parts of file names (stored
+// tasks, result files) so path separators and ".." are
+// not allowed.
+func ValidateTaskID(taskID string) error {
+	if taskID == "" || strings.ContainsAny(taskID, "/\\") || strings.Contains(taskID, "..") {
+		return fmt.Errorf("invalid task ID \"%s\"", taskID)
+	}
+	return nil
+}
+
 type Task struct {
-	TaskID  string      `json:"taskID"`
-	Status  int         `json:"status"`
-	Fn      string      `json:"fn"`
-	Args    interface{} `json:"args"`
-	Error   string      `json:"error"`
-	Result  interface{} `json:"result"`
-	Created int64       `json:"created"`
-	Updated int64       `json:"updated"`
+	TaskID      string        `json:"taskID"`
+	Status      int           `json:"status"`
+	Fn          string        `json:"fn"`
+	Args        interface{}   `json:"args"`
+	Error       string        `json:"error"`
+	Result      interface{}   `json:"result"`
+	Created     int64         `json:"created"`
+	Updated     int64         `json:"updated"`
+	Started     int64         `json:"started"`
+	ETA         int64         `json:"eta"`
+	Queue       string        `json:"queue"`
+	Pool        string        `json:"pool"`
+	Priority    int           `json:"priority"`
+	Attempt     int           `json:"attempt"`
+	MaxAttempts int           `json:"maxAttempts"`
+	Progress    *TaskProgress `json:"progress"`
+
+	// ErrorDetail describes the last error in a structured way
+	// (Error contains just its message)
+	ErrorDetail *TaskError `json:"errorDetail,omitempty"`
+
+	WorkflowID string `json:"workflowID,omitempty"`
+	DedupKey   string `json:"dedupKey,omitempty"`
+	Submitter  string `json:"submitter,omitempty"`
+
+	// Stderr contains the worker's stderr output
+	// written during the last failed attempt
+	Stderr []string `json:"stderr,omitempty"`
+
+	// ResultOffloaded says that the result is stored in a file
+	// (of size ResultSize) instead of the Result field
+	ResultOffloaded bool  `json:"resultOffloaded,omitempty"`
+	ResultSize      int64 `json:"resultSize,omitempty"`
+
+	// softLimitSent says whether the worker processing
+	// the task has been notified about soft exec. limit
+	softLimitSent bool
+}
+
+// clone returns a shallow copy of the task
+func (t *Task) clone() *Task {
+	ans := *t
+	return &ans
 }
 
 func (t *Task) IsDone() bool {
-	return t.Status == taskStatusFinished
+	return t.Status == taskStatusFinished || t.Status == taskStatusFailed ||
+		t.Status == taskStatusCancelled
+}
+
+// IsCancelled tests whether the task has been
+// cancelled by a client
+func (t *Task) IsCancelled() bool {
+	return t.Status == taskStatusCancelled
 }
 
 func (t *Task) String() string {
@@ -58,6 +171,12 @@ func (t *Task) AgeSecons() int {
 	return int(time.Now().Unix() - t.Created)
 }
 
+// RunningSeconds returns number of seconds since
+// the last execution attempt of the task started
+func (t *Task) RunningSeconds() int {
+	return int(time.Now().Unix() - t.Started)
+}
+
 func (t *Task) SecondsSinceUpdate() int {
 	return int(time.Now().Unix() - t.Updated)
 }
@@ -0,0 +1,253 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTaskListLimit = 100
+	maxTaskListLimit     = 1000
+)
+
+// sort keys of listed tasks
+const (
+	TaskSortCreated = "created"
+	TaskSortUpdated = "updated"
+	TaskSortStarted = "started"
+)
+
+var taskStatusNames = map[string]int{
+	"waiting":   taskStatusWaiting,
+	"running":   taskStatusRunning,
+	"finished":  taskStatusFinished,
+	"cancelled": taskStatusCancelled,
+	"scheduled": taskStatusScheduled,
+	"failed":    taskStatusFailed,
+}
+
+// ParseTaskStatus converts either a status name
+// (e.g. "running") or its numeric code to the code
+func ParseTaskStatus(s string) (int, error) {
+	if status, ok := taskStatusNames[s]; ok {
+		return status, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil || status < taskStatusWaiting || status > taskStatusFailed {
+		return -1, fmt.Errorf("invalid task status %s", s)
+	}
+	return status, nil
+}
+
+// TaskFilter specifies which tasks are returned by
+// Master.ListTasks and in which order. Zero values
+// mean "no restriction".
+type TaskFilter struct {
+	Statuses  []int
+	Fn        string
+	Submitter string
+
+	// CreatedFrom and CreatedTo specify a range (unix time,
+	// both inclusive) of task creation time
+	CreatedFrom int64
+	CreatedTo   int64
+
+	// SortBy is one of TaskSortCreated (default),
+	// TaskSortUpdated and TaskSortStarted
+	SortBy     string
+	Descending bool
+
+	// Limit specifies max. number of returned tasks
+	// (default 100, max. 1000)
+	Limit int
+
+	// Cursor is TaskPage.NextCursor of the previous page
+	Cursor string
+}
+
+// TaskPage is a single page of listed tasks
+type TaskPage struct {
+	Tasks []*Task `json:"tasks"`
+
+	// NextCursor is empty in case there are no more tasks
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// taskCursor identifies the last task of a page. As task IDs are
+// unique, the (sort key, task ID) pair defines a total order
+// and the next page can be found even if tasks are added
+// or removed in the meantime (keyset pagination).
+type taskCursor struct {
+	key    int64
+	taskID string
+}
+
+func (c taskCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.key, c.taskID)))
+}
+
+func decodeTaskCursor(s string) (taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return taskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	items := strings.SplitN(string(data), ":", 2)
+	if len(items) != 2 {
+		return taskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	key, err := strconv.ParseInt(items[0], 10, 64)
+	if err != nil {
+		return taskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	return taskCursor{key: key, taskID: items[1]}, nil
+}
+
+// sortKey returns a value tasks are sorted by
+func (f *TaskFilter) sortKey(task *Task) int64 {
+	switch f.SortBy {
+	case TaskSortUpdated:
+		return task.Updated
+	case TaskSortStarted:
+		return task.Started
+	default:
+		return task.Created
+	}
+}
+
+// before tests whether a task is placed
+// before a cursor position in the sort order
+func (f *TaskFilter) before(task *Task, cursor taskCursor) bool {
+	key := f.sortKey(task)
+	if key == cursor.key {
+		if f.Descending {
+			return task.TaskID > cursor.taskID
+		}
+		return task.TaskID < cursor.taskID
+	}
+	if f.Descending {
+		return key > cursor.key
+	}
+	return key < cursor.key
+}
+
+// after tests whether a task is placed
+// after a cursor position in the sort order
+func (f *TaskFilter) after(task *Task, cursor taskCursor) bool {
+	key := f.sortKey(task)
+	if key == cursor.key {
+		if f.Descending {
+			return task.TaskID < cursor.taskID
+		}
+		return task.TaskID > cursor.taskID
+	}
+	if f.Descending {
+		return key < cursor.key
+	}
+	return key > cursor.key
+}
+
+func (f *TaskFilter) validate() error {
+	switch f.SortBy {
+	case "", TaskSortCreated, TaskSortUpdated, TaskSortStarted:
+	default:
+		return fmt.Errorf("invalid sort key %s", f.SortBy)
+	}
+	if f.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", f.Limit)
+	}
+	return nil
+}
+
+func (f *TaskFilter) matches(task *Task) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if task.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return (f.Fn == "" || task.Fn == f.Fn) &&
+		(f.Submitter == "" || task.Submitter == f.Submitter) &&
+		(f.CreatedFrom == 0 || task.Created >= f.CreatedFrom) &&
+		(f.CreatedTo == 0 || task.Created <= f.CreatedTo)
+}
+
+// filterTasks returns a page of tasks matching a filter
+func filterTasks(tasks []*Task, filter *TaskFilter) (*TaskPage, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+	var cursor *taskCursor
+	if filter.Cursor != "" {
+		c, err := decodeTaskCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+	}
+	matching := make([]*Task, 0, len(tasks))
+	for _, task := range tasks {
+		if filter.matches(task) && (cursor == nil || filter.after(task, *cursor)) {
+			matching = append(matching, task)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return filter.before(matching[i], taskCursor{key: filter.sortKey(matching[j]), taskID: matching[j].TaskID})
+	})
+	limit := filter.Limit
+	if limit == 0 {
+		limit = defaultTaskListLimit
+
+	} else if limit > maxTaskListLimit {
+		limit = maxTaskListLimit
+	}
+	ans := &TaskPage{Tasks: matching}
+	if len(matching) > limit {
+		ans.Tasks = matching[:limit]
+		last := ans.Tasks[limit-1]
+		ans.NextCursor = taskCursor{key: filter.sortKey(last), taskID: last.TaskID}.encode()
+	}
+	return ans, nil
+}
+
+// ListTasks returns tasks matching a filter. The returned
+// tasks are copies without results (which can be large and
+// are available via GetTask) so they can be safely encoded
+// by the caller.
+func (m *Master) ListTasks(filter *TaskFilter) (*TaskPage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ans, err := filterTasks(m.tasks.List(), filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, task := range ans.Tasks {
+		ans.Tasks[i] = task.clone()
+		ans.Tasks[i].Result = nil
+	}
+	return ans, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTaskList() []*Task {
+	return []*Task{
+		{TaskID: "d", Fn: "a", Status: taskStatusWaiting, Created: 20, Submitter: "x"},
+		{TaskID: "b", Fn: "a", Status: taskStatusFinished, Created: 10},
+		{TaskID: "a", Fn: "b", Status: taskStatusFailed, Created: 10, Submitter: "x"},
+		{TaskID: "c", Fn: "a", Status: taskStatusRunning, Created: 30},
+	}
+}
+
+func taskIDs(page *TaskPage) []string {
+	ans := make([]string, len(page.Tasks))
+	for i, task := range page.Tasks {
+		ans[i] = task.TaskID
+	}
+	return ans
+}
+
+func TestParseTaskStatus(t *testing.T) {
+	status, err := ParseTaskStatus("failed")
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusFailed, status)
+	status, err = ParseTaskStatus("1")
+	assert.Nil(t, err)
+	assert.Equal(t, taskStatusRunning, status)
+	_, err = ParseTaskStatus("6")
+	assert.Error(t, err)
+}
+
+func TestFilterTasks(t *testing.T) {
+	page, err := filterTasks(testTaskList(), &TaskFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "d", "c"}, taskIDs(page))
+	assert.Equal(t, "", page.NextCursor)
+
+	page, _ = filterTasks(testTaskList(), &TaskFilter{Fn: "a", Descending: true})
+	assert.Equal(t, []string{"c", "d", "b"}, taskIDs(page))
+
+	page, _ = filterTasks(testTaskList(), &TaskFilter{Submitter: "x"})
+	assert.Equal(t, []string{"a", "d"}, taskIDs(page))
+
+	page, _ = filterTasks(testTaskList(), &TaskFilter{Statuses: []int{taskStatusWaiting, taskStatusRunning}})
+	assert.Equal(t, []string{"d", "c"}, taskIDs(page))
+
+	page, _ = filterTasks(testTaskList(), &TaskFilter{CreatedFrom: 15, CreatedTo: 25})
+	assert.Equal(t, []string{"d"}, taskIDs(page))
+
+	_, err = filterTasks(testTaskList(), &TaskFilter{SortBy: "fn"})
+	assert.Error(t, err)
+}
+
+func TestFilterTasksPagination(t *testing.T) {
+	for _, desc := range []bool{false, true} {
+		filter := &TaskFilter{Limit: 3, Descending: desc}
+		page, err := filterTasks(testTaskList(), filter)
+		assert.Nil(t, err)
+		ids := taskIDs(page)
+		assert.Equal(t, 3, len(ids))
+		assert.NotEqual(t, "", page.NextCursor)
+		filter.Cursor = page.NextCursor
+		page, err = filterTasks(testTaskList(), filter)
+		assert.Nil(t, err)
+		ids = append(ids, taskIDs(page)...)
+		assert.Equal(t, "", page.NextCursor)
+		if desc {
+			assert.Equal(t, []string{"c", "d", "b", "a"}, ids)
+
+		} else {
+			assert.Equal(t, []string{"a", "b", "d", "c"}, ids)
+		}
+	}
+	_, err := filterTasks(testTaskList(), &TaskFilter{Cursor: "foo"})
+	assert.Error(t, err)
+}
+
+func TestListTasksOmitsResults(t *testing.T) {
+	store := NewMemoryTaskStore()
+	store.Put(&Task{TaskID: "a", Fn: "foo", Status: taskStatusFinished, Created: 10, Result: "big"})
+	m := NewMaster(&MasterConf{PoolSize: 1}, store)
+	page, err := m.ListTasks(&TaskFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, taskIDs(page))
+	assert.Nil(t, page.Tasks[0].Result)
+	assert.Equal(t, "big", m.GetTask("a").Result)
+}
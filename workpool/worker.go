@@ -19,9 +19,44 @@ package workpool
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
+)
+
+const (
+	// workerInfoStderrLines specifies how many stderr lines
+	// are shown for each worker on the info page
+	workerInfoStderrLines = 10
+
+	// stderrSettleTime is a time we wait for stderr output
+	// of a failed task as stdout and stderr are read
+	// independently
+	stderrSettleTime = 50 * time.Millisecond
+
+	controlPing  = "ping"
+	controlPong  = "pong"
+	controlHello = "hello"
+
+	defaultHeartbeatMaxMissed = 3
+
+	// workerRestartMinDelay is a delay before a worker which
+	// crashed repeatedly (or failed to start) is started again.
+	// The delay doubles with each consecutive crash up to
+	// workerRestartMaxDelay.
+	workerRestartMinDelay = 1 * time.Second
+
+	workerRestartMaxDelay = 60 * time.Second
+
+	// workerStableUptime is a time after which a running
+	// worker process is not considered as crashing anymore
+	workerStableUptime = 60 * time.Second
 )
 
 const (
@@ -32,13 +67,42 @@ const (
 
 // WorkerStatus describes current
 // state and task (if applicable) info.
+// A worker may send any number of statuses
+// with Status = workerStatusRunning (typically
+// with Progress filled in) before the final one.
 type WorkerStatus struct {
-	TaskID    string      `json:"taskID"`
-	Status    int         `json:"status"`
-	Error     string      `json:"error"`
-	Traceback []string    `json:"traceback"`
-	Result    interface{} `json:"result"`
-	worker    *Worker
+	TaskID string `json:"taskID"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+
+	// ErrorKind optionally classifies the error
+	// (default is "application")
+	ErrorKind string `json:"errorKind"`
+
+	Traceback []string      `json:"traceback"`
+	Result    interface{}   `json:"result"`
+	Progress  *TaskProgress `json:"progress"`
+
+	// Control is set in case of a control message
+	// ("pong" or "hello") which is not related
+	// to any task
+	Control string `json:"control"`
+
+	// Functions contains task functions supported by the worker.
+	// It is sent within the "hello" message once the worker starts.
+	Functions []FunctionInfo `json:"functions"`
+
+	// ResultFile is a path to a file containing JSON-encoded
+	// result. Workers use it instead of Result in case the
+	// result is too large to be passed via the response pipe.
+	ResultFile string `json:"resultFile"`
+
+	worker     *Worker
+	generation int      // the worker's process the status comes from (see Worker.generation)
+	crashed    bool     // the worker process exited unexpectedly
+	pid        int      // PID of the crashed process
+	exitCode   *int     // exit code of the crashed process
+	stderr     []string // stderr output written during a failed task
 }
 
 func (ws *WorkerStatus) IsDone() bool {
@@ -49,6 +113,14 @@ func (ws *WorkerStatus) Worker() *Worker {
 	return ws.worker
 }
 
+// isStale tests whether the status comes from a process
+// the worker has already replaced (e.g. a late answer of
+// a process restarted due to task cancellation). Master
+// must call this with its mutex locked.
+func (ws *WorkerStatus) isStale() bool {
+	return ws.generation != ws.worker.generation
+}
+
 func (ws *WorkerStatus) ReadableStatus() string {
 	switch ws.Status {
 	case workerStatusRunning:
@@ -66,8 +138,17 @@ func (ws *WorkerStatus) ReadableStatus() string {
 
 type WorkerInfo struct {
 	PID        int
+	Pool       string
 	LastStatus string
 	TaskID     string
+	TasksDone  int
+	Recycled   int
+	RSS        int64
+	StderrTail []string
+
+	// MissedHeartbeats is number of consecutive
+	// heartbeat pings the worker has not answered
+	MissedHeartbeats int
 }
 
 // ----------------------------------------------
@@ -84,12 +165,32 @@ type Worker struct {
 	commandName               string
 	args                      []string
 	cmd                       *exec.Cmd
+	stopped                   chan bool // closed once we stop the process
 	commandsPipe              *CommandPipe
 	responsesPipe             *ResponsePipe
 	workerEvent               chan *WorkerStatus
-	lastEvent                 WorkerStatus // this is used only for overview purposes
+	lastEvent                 atomic.Value // WorkerStatus, used only for overview purposes
 	taskID                    string
 	maxResponsePipeBufferSize int
+	idleSince                 time.Time
+	tasksDone                 int // number of tasks executed by the current process
+	recycled                  int // number of process replacements due to limits
+	stderr                    *stderrBuffer
+	stderrMark                int64     // stderr line the current task started at (atomic)
+	awaitingPong              int32     // 1 if a ping has not been answered yet (atomic)
+	lastPing                  time.Time // time of the last heartbeat ping
+	missedHeartbeats          int
+	pool                      *workerPool
+	startedAt                 time.Time // time the current process has been started
+	crashes                   int       // number of consecutive crashes (incl. failed starts)
+	restartAt                 time.Time // time of a postponed start (zero if the worker is up)
+	generation                int       // incremented each time a new process is started
+}
+
+// workerControl is a control message sent to the worker.
+// Workers distinguish it from workerCall by the "control" key.
+type workerControl struct {
+	Control string `json:"control"`
 }
 
 // workerCall describe a single function call
@@ -102,12 +203,15 @@ type workerCall struct {
 }
 
 // NewWorker is a default factory for Worker
-func NewWorker(workerEvent chan *WorkerStatus, maxResponsePipeBufferSize int, command string, args ...string) *Worker {
+func NewWorker(workerEvent chan *WorkerStatus, maxResponsePipeBufferSize int, stderrBufferLines int,
+	command string, args ...string) *Worker {
 	return &Worker{
 		commandName:               command,
 		args:                      args,
 		workerEvent:               workerEvent,
 		maxResponsePipeBufferSize: maxResponsePipeBufferSize,
+		idleSince:                 time.Now(),
+		stderr:                    newStderrBuffer(stderrBufferLines),
 	}
 }
 
@@ -126,74 +230,132 @@ func (w *Worker) GetPID() int {
 
 // Start runs the Worker - both communication in-memory pipes are
 // set and the Worker is listening via a specific channel to
-// responses of the task.
-func (w *Worker) Start() {
+// responses of the task. In case the process cannot be started,
+// the worker is left stopped and an error is returned.
+func (w *Worker) Start() error {
+	w.tasksDone = 0
+	w.missedHeartbeats = 0
+	w.lastPing = time.Now()
+	w.startedAt = time.Now()
+	atomic.StoreInt32(&w.awaitingPong, 0)
+	w.generation++
+	generation := w.generation
+	stopped := make(chan bool)
+	w.stopped = stopped
 	w.commandsPipe = NewCommandPipe()
 	w.responsesPipe = NewResponsePipe(w.maxResponsePipeBufferSize)
-	var err error
 	w.cmd = exec.Command(w.commandName, w.args...)
 	w.responsesPipe.Register(w.cmd)
-	w.commandsPipe.Register(w.cmd)
+	err := w.commandsPipe.Register(w.cmd)
+	if err != nil {
+		w.Stop()
+		return err
+	}
+	stderrPipe, err := w.cmd.StderrPipe()
+	if err != nil {
+		w.Stop()
+		return err
+	}
 
 	ch := w.responsesPipe.Channel()
 
 	go func() {
-		for {
+		// the channel is closed once the pipe is closed by Stop()
+		for data := range ch {
 			select {
-			case data := <-ch:
-				log.Print("GOT FROM PIPE ", data)
-				var ans WorkerStatus
-				var err error
-				err = json.Unmarshal([]byte(data), &ans)
-				log.Print("DECODED FROM PIPE: ", ans)
-				if err != nil {
-					ans.TaskID = w.taskID
-					ans.worker = w
-					ans.Error = err.Error()
-					// TODO
-					log.Print("ERROR: failed to parse worker response: ", err)
-
-				} else {
-					ans.TaskID = w.taskID
-					ans.worker = w
-				}
-				w.lastEvent = ans
-				w.workerEvent <- &ans
+			case <-stopped:
+				continue // a late line of a stopped process
+			default:
+			}
+			var ans WorkerStatus
+			var err error
+			err = json.Unmarshal([]byte(data), &ans)
+			if err == nil && ans.Control == controlPong {
+				atomic.StoreInt32(&w.awaitingPong, 0)
+				continue
 
+			} else if err == nil && ans.Control == controlHello {
+				ans.worker = w
+				ans.generation = generation
+				w.workerEvent <- &ans
+				continue
+			}
+			log.Print("GOT FROM PIPE ", data)
+			log.Print("DECODED FROM PIPE: ", ans)
+			// the task the status relates to is resolved by Master
+			ans.worker = w
+			ans.generation = generation
+			if err != nil {
+				ans.Error = err.Error()
+				ans.ErrorKind = errorKindProtocol
+				// TODO
+				log.Print("ERROR: failed to parse worker response: ", err)
 			}
+			if ans.IsDone() && ans.Error != "" {
+				time.Sleep(stderrSettleTime)
+				ans.stderr = w.TaskStderr()
+			}
+			w.lastEvent.Store(ans)
+			w.workerEvent <- &ans
 		}
 	}()
 	err = w.cmd.Start()
 	if err != nil {
-		log.Print("ERROR: ", err) // TODO
+		w.Stop()
+		return err
 	}
+	cmd := w.cmd
+	pid := w.GetPID()
+	stderrDone := make(chan bool)
 	go func() {
-		err := w.cmd.Wait()
-		if err != nil {
-			switch terr := err.(type) {
-			case *exec.ExitError:
-				w.workerEvent <- &WorkerStatus{
-					TaskID: w.taskID,
-					worker: w,
-					Error:  terr.Error(), // TODO
-				}
-			default:
-				w.workerEvent <- &WorkerStatus{
-					TaskID: w.taskID,
-					worker: w,
-					Error:  err.Error(),
-				}
-			}
+		if stderrPipe != nil {
+			w.stderr.consume(stderrPipe, pid)
 		}
+		close(stderrDone)
 	}()
-
+	go func() {
+		// Wait closes the stderr pipe so we have to
+		// read everything first (e.g. a traceback
+		// of a crashed worker)
+		<-stderrDone
+		err := cmd.Wait()
+		select {
+		case <-stopped:
+			return // the process has been stopped by us
+		default:
+		}
+		if err == nil {
+			err = fmt.Errorf("worker process exited")
+		}
+		// the task is resolved by Master (which also checks
+		// the event relates to the worker's current process)
+		w.workerEvent <- &WorkerStatus{
+			worker:     w,
+			generation: generation,
+			Error:      err.Error(),
+			crashed:    true,
+			pid:        pid,
+			exitCode:   processExitCode(err),
+			stderr:     w.TaskStderr(),
+		}
+	}()
+	return nil
 }
 
-// Stop kills the external task
+// Stop kills the external task. Stopping an already
+// stopped worker (e.g. the one which failed to start)
+// does nothing.
 func (w *Worker) Stop() {
-	w.cmd.Process.Kill()
-	w.commandsPipe.reader.Close()
-	w.commandsPipe.writer.Close()
+	select {
+	case <-w.stopped:
+		return
+	default:
+	}
+	close(w.stopped)
+	if w.cmd.Process != nil {
+		w.cmd.Process.Kill()
+	}
+	w.commandsPipe.Close()
 	w.responsesPipe.reader.Close()
 	w.responsesPipe.writer.Close()
 }
@@ -214,19 +376,141 @@ func (w *Worker) Call(taskID string, fn string, args interface{}) {
 		log.Print("ERROR: ", err)
 	}
 	w.taskID = taskID
+	atomic.StoreInt64(&w.stderrMark, int64(w.stderr.mark()))
 	w.commandsPipe.SendBytes(js)
 }
 
+// Ping sends a heartbeat ping to the worker. The worker
+// is expected to respond with a "pong" control message.
+func (w *Worker) Ping() {
+	js, err := json.Marshal(workerControl{Control: controlPing})
+	if err != nil {
+		log.Print("ERROR: ", err)
+		return
+	}
+	atomic.StoreInt32(&w.awaitingPong, 1)
+	w.lastPing = time.Now()
+	w.commandsPipe.SendBytes(js)
+}
+
+// pingMissed tests whether the last ping
+// has not been answered yet
+func (w *Worker) pingMissed() bool {
+	return atomic.LoadInt32(&w.awaitingPong) == 1
+}
+
+// IsUnresponsive tests whether the worker
+// has missed at least one heartbeat
+func (w *Worker) IsUnresponsive() bool {
+	return w.missedHeartbeats > 0 && w.pingMissed()
+}
+
+// TaskStderr returns lines written to stderr since
+// the current (or the last) task has been sent to the worker
+func (w *Worker) TaskStderr() []string {
+	return w.stderr.since(int(atomic.LoadInt64(&w.stderrMark)))
+}
+
 // Reload sends SIGHUP to the running task
 func (w *Worker) Reload() {
-	w.cmd.Process.Signal(syscall.SIGHUP)
+	if w.cmd.Process != nil {
+		w.cmd.Process.Signal(syscall.SIGHUP)
+	}
+}
+
+// SignalSoftLimit sends SIGUSR1 to the running task
+// to notify it that its soft execution time limit
+// has been reached.
+func (w *Worker) SignalSoftLimit() {
+	if w.cmd.Process != nil {
+		w.cmd.Process.Signal(syscall.SIGUSR1)
+	}
+}
+
+// isDown tests whether the worker waits for
+// a postponed start (see restartDelay)
+func (w *Worker) isDown() bool {
+	return !w.restartAt.IsZero()
+}
+
+// restartDelay returns a time the worker has to wait before
+// it is started again after a crash. The first crash
+// is handled by an immediate restart, then the delay
+// grows exponentially.
+func (w *Worker) restartDelay() time.Duration {
+	if w.crashes <= 1 {
+		return 0
+	}
+	delay := workerRestartMinDelay
+	for i := 2; i < w.crashes && delay < workerRestartMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > workerRestartMaxDelay {
+		return workerRestartMaxDelay
+	}
+	return delay
+}
+
+// processRSS returns resident set size of a process.
+// It can be replaced in tests.
+var processRSS = readProcessRSS
+
+// readProcessRSS reads resident set size (in bytes)
+// of a process from /proc so it works on Linux only.
+func readProcessRSS(pid int) (int64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return -1, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return -1, fmt.Errorf("unexpected statm format: %s", data)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
+
+// RSS returns resident set size (in bytes) of the
+// worker process.
+func (w *Worker) RSS() (int64, error) {
+	return processRSS(w.GetPID())
+}
+
+// lastEventStatus returns a readable status of the last
+// event received from the worker process. The event is
+// written by the response reading goroutine so it must
+// be accessed atomically.
+func (w *Worker) lastEventStatus() string {
+	var ev WorkerStatus
+	if v, ok := w.lastEvent.Load().(WorkerStatus); ok {
+		ev = v
+	}
+	return ev.ReadableStatus()
 }
 
 func (w *Worker) Info() WorkerInfo {
+	rss, err := w.RSS()
+	if err != nil {
+		rss = -1
+	}
+	lastStatus := w.lastEventStatus()
+	if w.IsUnresponsive() {
+		lastStatus = "unresponsive"
+	}
 	ans := WorkerInfo{
 		PID:        w.GetPID(),
+		Pool:       w.pool.conf.Name,
 		TaskID:     w.taskID,
-		LastStatus: w.lastEvent.ReadableStatus(),
+		LastStatus: lastStatus,
+		TasksDone:  w.tasksDone,
+		Recycled:   w.recycled,
+		RSS:        rss,
+		StderrTail: w.stderr.tail(workerInfoStderrLines),
+
+		MissedHeartbeats: w.missedHeartbeats,
 	}
 	return ans
 }
@@ -0,0 +1,172 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// WorkflowChain executes tasks one by one, each next
+	// task obtains the result of the previous one
+	WorkflowChain = "chain"
+
+	// WorkflowGroup executes tasks in parallel
+	WorkflowGroup = "group"
+
+	// WorkflowChord executes tasks in parallel and once
+	// all of them are finished, a callback task obtaining
+	// a list of their results is executed
+	WorkflowChord = "chord"
+
+	// parentResultArg is an argument name used to pass
+	// a result of a previous task in case the next task's
+	// arguments are an object
+	parentResultArg = "parent_result"
+)
+
+// WorkflowTaskSpec describes a single task of a workflow
+type WorkflowTaskSpec struct {
+	Fn    string      `json:"fn"`
+	Args  interface{} `json:"args"`
+	Queue string      `json:"queue"`
+}
+
+// WorkflowSpec is a client's description of a workflow
+type WorkflowSpec struct {
+	Type     string             `json:"type"`
+	Tasks    []WorkflowTaskSpec `json:"tasks"`
+	Callback *WorkflowTaskSpec  `json:"callback"`
+}
+
+// Validate tests whether the specification is complete
+func (ws *WorkflowSpec) Validate() error {
+	switch ws.Type {
+	case WorkflowChain, WorkflowGroup:
+		if ws.Callback != nil {
+			return fmt.Errorf("callback is supported only by chord workflows")
+		}
+	case WorkflowChord:
+		if ws.Callback == nil || ws.Callback.Fn == "" {
+			return fmt.Errorf("chord workflow requires a callback")
+		}
+	default:
+		return fmt.Errorf("unknown workflow type \"%s\"", ws.Type)
+	}
+	if len(ws.Tasks) == 0 {
+		return fmt.Errorf("workflow has no tasks")
+	}
+	for i, t := range ws.Tasks {
+		if t.Fn == "" {
+			return fmt.Errorf("task %d has no fn", i)
+		}
+	}
+	return nil
+}
+
+// Workflow is a group of tasks executed by Master
+// according to a WorkflowSpec. Workflows are kept
+// in memory only (i.e. unlike tasks, they do not
+// survive konserver restart).
+type Workflow struct {
+	WorkflowID string `json:"workflowID"`
+	Type       string `json:"type"`
+
+	// Status uses the same values as Task.Status
+	Status int `json:"status"`
+
+	Error string `json:"error"`
+
+	// Result is the result of the last task of a chain,
+	// a list of results in case of a group and the result
+	// of the callback in case of a chord
+	Result interface{} `json:"result"`
+
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+
+	// TaskIDs contains IDs of already submitted tasks
+	// (excluding the callback) in order of the specification
+	TaskIDs []string `json:"taskIDs"`
+
+	CallbackTaskID string `json:"callbackTaskID,omitempty"`
+
+	// Tasks contains current state of workflow's tasks.
+	// It is filled only in snapshots provided to clients.
+	Tasks []*Task `json:"tasks,omitempty"`
+
+	spec *WorkflowSpec
+}
+
+// IsDone tests whether the workflow reached its final status
+func (w *Workflow) IsDone() bool {
+	return w.Status == taskStatusFinished || w.Status == taskStatusFailed ||
+		w.Status == taskStatusCancelled
+}
+
+func (w *Workflow) finish(result interface{}, errMsg string) {
+	w.Status = taskStatusFinished
+	if errMsg != "" {
+		w.Status = taskStatusFailed
+	}
+	w.Result = result
+	w.Error = errMsg
+	w.Updated = time.Now().Unix()
+}
+
+func (w *Workflow) cancel() {
+	w.Status = taskStatusCancelled
+	w.Updated = time.Now().Unix()
+}
+
+// taskFailure returns a description of task failure
+// or an empty string in case the task succeeded.
+func taskFailure(task *Task) string {
+	if task.Status == taskStatusCancelled {
+		return fmt.Sprintf("task %s cancelled", task.TaskID)
+	}
+	if task.Status == taskStatusFailed {
+		return fmt.Sprintf("task %s failed: %s", task.TaskID, task.Error)
+	}
+	return ""
+}
+
+// chainArgs creates arguments of a task following a task
+// which returned parentResult. If the task has no arguments
+// specified, the parent result is used as a whole. In case
+// of a list, the result is prepended and in case of an object,
+// the result is passed as "parent_result".
+func chainArgs(args interface{}, parentResult interface{}) interface{} {
+	switch tArgs := args.(type) {
+	case nil:
+		return parentResult
+	case []interface{}:
+		ans := make([]interface{}, 0, len(tArgs)+1)
+		ans = append(ans, parentResult)
+		return append(ans, tArgs...)
+	case map[string]interface{}:
+		ans := make(map[string]interface{}, len(tArgs)+1)
+		for k, v := range tArgs {
+			ans[k] = v
+		}
+		ans[parentResultArg] = parentResult
+		return ans
+	default:
+		return []interface{}{parentResult, tArgs}
+	}
+}
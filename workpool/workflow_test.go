@@ -0,0 +1,231 @@
+// Copyright 2018 Tomas Machalek <tomas.machalek@gmail.com>
+// Copyright (c) 2018 Charles University, Faculty of Arts,
+//                    Institute of the Czech National Corpus
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// workflowWorker returns the call it received as the result
+// so arguments passed between tasks can be checked. Function
+// "fail" ends with an error, "slow" takes 3 s.
+const workflowWorker = `while read line; do
+	case "$line" in
+	*'"fn":"fail"'*) echo '{"status": 0, "error": "boom"}';;
+	*'"fn":"slow"'*) sleep 3; echo "{\"status\": 0, \"result\": $line}";;
+	*) echo "{\"status\": 0, \"result\": $line}";;
+	esac
+done`
+
+func newWorkflowMaster() *Master {
+	return NewMaster(&MasterConf{
+		PoolSize:       2,
+		Program:        "sh",
+		ProgramArgs:    []string{"-c", workflowWorker},
+		ExecMaxSeconds: 10,
+
+		TaskResultPersistMaxSeconds: 60,
+	}, NewMemoryTaskStore())
+}
+
+func waitForWorkflow(t *testing.T, m *Master, wf *Workflow) *Workflow {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ans := m.GetWorkflow(wf.WorkflowID); ans.IsDone() {
+			return ans
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("workflow %s not finished", wf.WorkflowID)
+	return nil
+}
+
+// call extracts the worker call returned as a task result
+func call(value interface{}) map[string]interface{} {
+	ans, _ := value.(map[string]interface{})
+	return ans
+}
+
+func TestChainArgsNoArgs(t *testing.T) {
+	parent := map[string]interface{}{"cachefile": "/tmp/foo.conc"}
+	assert.Equal(t, parent, chainArgs(nil, parent))
+}
+
+func TestChainArgsList(t *testing.T) {
+	ans := chainArgs([]interface{}{"a", 1.0}, "res")
+	assert.Equal(t, []interface{}{"res", "a", 1.0}, ans)
+}
+
+func TestChainArgsObject(t *testing.T) {
+	args := map[string]interface{}{"fcrit": "word/e 0"}
+	ans := chainArgs(args, "res")
+	assert.Equal(t, map[string]interface{}{"fcrit": "word/e 0", "parent_result": "res"}, ans)
+	_, modified := args["parent_result"]
+	assert.False(t, modified)
+}
+
+func TestWorkflowSpecValidate(t *testing.T) {
+	spec := &WorkflowSpec{Type: WorkflowChord, Tasks: []WorkflowTaskSpec{{Fn: "a"}}}
+	assert.NotNil(t, spec.Validate())
+	spec.Callback = &WorkflowTaskSpec{Fn: "b"}
+	assert.Nil(t, spec.Validate())
+	spec.Type = "pipeline"
+	assert.NotNil(t, spec.Validate())
+}
+
+func TestChainPassesResults(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type: WorkflowChain,
+		Tasks: []WorkflowTaskSpec{
+			{Fn: "a", Args: map[string]interface{}{"x": 1}},
+			{Fn: "b", Args: map[string]interface{}{"y": 2}},
+			{Fn: "c"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(wf.TaskIDs))
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFinished, wf.Status)
+	assert.Equal(t, 3, len(wf.TaskIDs))
+	result := call(wf.Result)
+	assert.Equal(t, "c", result["fn"])
+	// "c" has no args so it obtains the whole result of "b"
+	bCall := call(result["args"])
+	assert.Equal(t, "b", bCall["fn"])
+	bArgs := call(bCall["args"])
+	assert.Equal(t, 2.0, bArgs["y"])
+	assert.Equal(t, "a", call(bArgs[parentResultArg])["fn"])
+}
+
+func TestChainStopsOnFailure(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:  WorkflowChain,
+		Tasks: []WorkflowTaskSpec{{Fn: "a"}, {Fn: "fail"}, {Fn: "c"}},
+	})
+	assert.Nil(t, err)
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFailed, wf.Status)
+	assert.Contains(t, wf.Error, "boom")
+	assert.Equal(t, 2, len(wf.TaskIDs))
+}
+
+func TestChainCancelled(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:  WorkflowChain,
+		Tasks: []WorkflowTaskSpec{{Fn: "slow"}, {Fn: "b"}},
+	})
+	assert.Nil(t, err)
+	time.Sleep(200 * time.Millisecond)
+	m.CancelTask(wf.TaskIDs[0])
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusCancelled, wf.Status)
+	assert.Equal(t, 1, len(wf.TaskIDs))
+}
+
+func TestGroupWaitsForAllTasks(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:  WorkflowGroup,
+		Tasks: []WorkflowTaskSpec{{Fn: "a"}, {Fn: "b"}, {Fn: "c"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(wf.TaskIDs))
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFinished, wf.Status)
+	results, ok := wf.Result.([]interface{})
+	if assert.True(t, ok) && assert.Equal(t, 3, len(results)) {
+		for i, fn := range []string{"a", "b", "c"} {
+			assert.Equal(t, fn, call(results[i])["fn"])
+		}
+	}
+}
+
+func TestGroupFailure(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:  WorkflowGroup,
+		Tasks: []WorkflowTaskSpec{{Fn: "a"}, {Fn: "fail"}},
+	})
+	assert.Nil(t, err)
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFailed, wf.Status)
+	assert.Nil(t, wf.Result)
+}
+
+func TestChordSubmitsCallback(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:     WorkflowChord,
+		Tasks:    []WorkflowTaskSpec{{Fn: "a"}, {Fn: "b"}},
+		Callback: &WorkflowTaskSpec{Fn: "cb", Args: []interface{}{"z"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "", wf.CallbackTaskID)
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFinished, wf.Status)
+	assert.NotEqual(t, "", wf.CallbackTaskID)
+	assert.Equal(t, 3, len(wf.Tasks))
+	result := call(wf.Result)
+	assert.Equal(t, "cb", result["fn"])
+	args, ok := result["args"].([]interface{})
+	if assert.True(t, ok) && assert.Equal(t, 2, len(args)) {
+		results := args[0].([]interface{})
+		assert.Equal(t, "a", call(results[0])["fn"])
+		assert.Equal(t, "b", call(results[1])["fn"])
+		assert.Equal(t, "z", args[1])
+	}
+}
+
+func TestChordFailureSkipsCallback(t *testing.T) {
+	m := newWorkflowMaster()
+	m.Start()
+	defer m.Stop()
+	wf, err := m.SendWorkflow(&WorkflowSpec{
+		Type:     WorkflowChord,
+		Tasks:    []WorkflowTaskSpec{{Fn: "fail"}, {Fn: "b"}},
+		Callback: &WorkflowTaskSpec{Fn: "cb"},
+	})
+	assert.Nil(t, err)
+	wf = waitForWorkflow(t, m, wf)
+
+	assert.Equal(t, taskStatusFailed, wf.Status)
+	assert.Equal(t, "", wf.CallbackTaskID)
+}